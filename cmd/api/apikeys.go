@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/models"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errInvalidAPIKey = apperror.Unauthorized("invalid api key").WithCode("invalid_api_key")
+
+// authenticateAPIKey resolves the value of an X-API-Key header to the
+// principal the key acts as.
+func (app *application) authenticateAPIKey(ctx context.Context, value string) (*Principal, error) {
+	if !strings.HasPrefix(value, models.APIKeyPrefix) {
+		return nil, errInvalidAPIKey
+	}
+	key, err := app.DB.GetAPIKeyByHash(ctx, models.HashToken(value))
+	if err != nil {
+		if apperror.Is(err, apperror.KindNotFound) {
+			return nil, errInvalidAPIKey
+		}
+		return nil, err
+	}
+	if !key.Active(time.Now()) {
+		return nil, errInvalidAPIKey
+	}
+	permissions, err := app.DB.UserPermissions(ctx, key.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	//last-used tracking is best effort and must not fail the request
+	err = app.DB.TouchAPIKey(ctx, key.ID)
+	if err != nil {
+		log.Println("recording api key use:", err)
+	}
+	return principalFromAPIKey(key, permissions), nil
+}
+
+func (app *application) AllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.DB.AllAPIKeys(r.Context())
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, keys)
+}
+
+func (app *application) InsertAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal := app.principal(r)
+	var requestPayload struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	key := models.APIKey{
+		UserID:    principal.UserID,
+		Name:      strings.TrimSpace(requestPayload.Name),
+		Scopes:    requestPayload.Scopes,
+		ExpiresAt: requestPayload.ExpiresAt,
+	}
+	err = key.Validate()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	//a key can never do more than the admin who created it
+	for _, scope := range key.Scopes {
+		if !principal.HasScope(scope) {
+			app.errorJSON(w, r, apperror.Forbidden("cannot grant permission "+scope))
+			return
+		}
+	}
+
+	value, hash, prefix, err := models.GenerateAPIKey()
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	key.KeyHash = hash
+	key.Prefix = prefix
+	key.ID, err = app.DB.InsertAPIKey(r.Context(), &key)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	key.CreatedAt = time.Now()
+
+	//the key itself is only ever shown here
+	resp := struct {
+		Key    string         `json:"key"`
+		APIKey *models.APIKey `json:"api_key"`
+	}{
+		Key:    value,
+		APIKey: &key,
+	}
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+func (app *application) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	err = app.DB.RevokeAPIKey(r.Context(), keyId)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "api key revoked!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"go-restapi/inernal/models"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiKeyRequest is req sent with an X-API-Key header instead of a token.
+func apiKeyRequest(req testRequest, key string) testRequest {
+	req.header = http.Header{"X-Api-Key": {key}}
+	return req
+}
+
+func TestInsertAPIKeyStoresOnlyTheHash(t *testing.T) {
+	app, db := newTestApp(t)
+	addUserWithRoles(t, db, "admin@example.com", "admin")
+	admin, _ := app.signIn(t, "admin@example.com", "correct horse")
+
+	w := app.do(t, testRequest{
+		method: "POST",
+		path:   "/admin/api-keys",
+		body:   `{"name":"importer","scopes":["movies:write"]}`,
+		token:  admin,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating key: status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Key    string         `json:"key"`
+		APIKey *models.APIKey `json:"api_key"`
+	}
+	decode(t, w, &resp)
+	if !strings.HasPrefix(resp.Key, models.APIKeyPrefix) || !strings.HasPrefix(resp.Key, resp.APIKey.Prefix) {
+		t.Errorf("key %q, prefix %q", resp.Key, resp.APIKey.Prefix)
+	}
+	if strings.Contains(w.Body.String(), models.HashToken(resp.Key)) {
+		t.Error("response contains the key hash")
+	}
+	stored := db.apiKeys[resp.APIKey.ID]
+	if stored.KeyHash != models.HashToken(resp.Key) || strings.Contains(stored.KeyHash, resp.Key) {
+		t.Errorf("stored hash %q for key %q", stored.KeyHash, resp.Key)
+	}
+
+	w = app.do(t, apiKeyRequest(testRequest{method: "DELETE", path: "/admin/movies/x"}, resp.Key))
+	if w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+		t.Errorf("new key: status %d", w.Code)
+	}
+	//the hash itself is not a key
+	w = app.do(t, apiKeyRequest(testRequest{method: "DELETE", path: "/admin/movies/x"}, stored.KeyHash))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("key hash used as key: status %d, want 401", w.Code)
+	}
+}
+
+func TestInsertAPIKeyLimitedToCreator(t *testing.T) {
+	app, db := newTestApp(t)
+	user := addUserWithRoles(t, db, "admin@example.com", "admin")
+	db.permissions[int(user.ID)] = []string{models.PermissionUsersWrite}
+	admin, _ := app.signIn(t, "admin@example.com", "correct horse")
+
+	w := app.do(t, testRequest{
+		method: "POST",
+		path:   "/admin/api-keys",
+		body:   `{"name":"importer","scopes":["movies:write"]}`,
+		token:  admin,
+	})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("granting a permission the creator lacks: status %d, want 403", w.Code)
+	}
+	if len(db.apiKeys) != 0 {
+		t.Error("key stored")
+	}
+}
+
+func TestAPIKeyExpiryAndRevocation(t *testing.T) {
+	app, db := newTestApp(t)
+	user := addUserWithRoles(t, db, "admin@example.com", "admin")
+	expired := time.Now().Add(-time.Second)
+	later := time.Now().Add(time.Hour)
+	req := testRequest{method: "DELETE", path: "/admin/movies/x"}
+
+	tests := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{"no expiry", db.addAPIKey(int(user.ID), nil, models.PermissionMoviesWrite), http.StatusBadRequest},
+		{"expires later", db.addAPIKey(int(user.ID), &later, models.PermissionMoviesWrite), http.StatusBadRequest},
+		{"expired", db.addAPIKey(int(user.ID), &expired, models.PermissionMoviesWrite), http.StatusUnauthorized},
+		{"unknown", models.APIKeyPrefix + "unknown", http.StatusUnauthorized},
+		{"without prefix", "unknown", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := app.do(t, apiKeyRequest(req, tt.key)); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+	if db.apiKeys[1].LastUsedAt == nil {
+		t.Error("use of the key not recorded")
+	}
+
+	err := db.RevokeAPIKey(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := app.do(t, apiKeyRequest(req, tests[0].key)); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status %d, want 401", w.Code)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	app, db := newTestApp(t)
+	user := addUserWithRoles(t, db, "admin@example.com", "admin")
+	key := db.addAPIKey(int(user.ID), nil, models.PermissionMoviesWrite)
+
+	//the key can do what it was granted and nothing else its creator can
+	if w := app.do(t, apiKeyRequest(testRequest{method: "DELETE", path: "/admin/movies/x"}, key)); w.Code != http.StatusBadRequest {
+		t.Errorf("granted scope: status %d, want 400", w.Code)
+	}
+	for _, req := range []testRequest{
+		{method: "DELETE", path: "/admin/genres/x"},
+		{method: "GET", path: "/admin/api-keys"},
+		{method: "POST", path: "/admin/users/1/impersonate"},
+	} {
+		if w := app.do(t, apiKeyRequest(req, key)); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status %d, want 403", req.method, req.path, w.Code)
+		}
+	}
+}
+
+func TestAPIKeyFollowsCreatorPermissions(t *testing.T) {
+	app, db := newTestApp(t)
+	addUserWithRoles(t, db, "admin@example.com", "admin")
+	editor := addUserWithRoles(t, db, "editor@example.com", "editor")
+	admin, _ := app.signIn(t, "admin@example.com", "correct horse")
+	key := db.addAPIKey(int(editor.ID), nil, models.PermissionMoviesWrite)
+	req := apiKeyRequest(testRequest{method: "DELETE", path: "/admin/movies/x"}, key)
+
+	if w := app.do(t, req); w.Code != http.StatusBadRequest {
+		t.Fatalf("before the role change: status %d, want 400", w.Code)
+	}
+	w := app.do(t, testRequest{method: "PUT", path: "/admin/users/2/roles", body: `{"roles":["viewer"]}`, token: admin})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("assigning viewer: status %d: %s", w.Code, w.Body)
+	}
+	if w := app.do(t, req); w.Code != http.StatusForbidden {
+		t.Errorf("after the creator lost movies:write: status %d, want 403", w.Code)
+	}
+
+	//giving the permission back restores the key
+	w = app.do(t, testRequest{method: "PUT", path: "/admin/users/2/roles", body: `{"roles":["editor"]}`, token: admin})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("assigning editor: status %d: %s", w.Code, w.Body)
+	}
+	if w := app.do(t, req); w.Code != http.StatusBadRequest {
+		t.Errorf("after the creator got movies:write back: status %d, want 400", w.Code)
+	}
+}
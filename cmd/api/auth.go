@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
@@ -18,54 +21,119 @@ type Auth struct {
 	CookieDomain  string
 	CookiePath    string
 	CookieName    string
+	// Keys signs tokens with an asymmetric key when set; otherwise tokens
+	// are signed with Secret using HS256. With Keys set, a non-empty Secret
+	// only verifies the HS256 tokens issued before the switch.
+	Keys *KeySet
+	// Denylist, when set, is consulted for access tokens revoked before
+	// they expire, on their own or because their session was ended. Without
+	// it a signed-out access token stays usable until it expires.
+	Denylist TokenDenylist
 }
 
+// TokenDenylist reports access tokens that were revoked by their jti, or
+// whose session, named by the sid claim, was revoked.
+type TokenDenylist interface {
+	IsAccessTokenRevoked(ctx context.Context, jti, sessionId string) (bool, error)
+}
+
+var errTokenRevoked = errors.New("token has been revoked")
+
 type jwtUser struct {
-	ID        int64  `json:"id"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
+	ID          int64    `json:"id"`
+	FirstName   string   `json:"first_name"`
+	LastName    string   `json:"last_name"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	// SessionID is the refresh token family the tokens are issued for.
+	SessionID string `json:"-"`
 }
 
 type TokenPairs struct {
-	Token        string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	Token            string    `json:"access_token"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshTokenID   string    `json:"-"`
+	RefreshExpiresAt time.Time `json:"-"`
 }
 
+const (
+	tokenTypeAccess            = "access"
+	tokenTypeRefresh           = "refresh"
+	tokenTypeEmailVerification = "email_verification"
+	tokenTypeMFA               = "mfa"
+	tokenTypeOIDCState         = "oidc_state"
+)
+
 type Claims struct {
+	Name  string   `json:"name,omitempty"`
+	Type  string   `json:"typ"`
+	Roles []string `json:"roles,omitempty"`
+	// Scope holds the caller's permissions, space separated as in OAuth 2.0.
+	Scope string `json:"scope,omitempty"`
+	Email string `json:"email,omitempty"`
+	// SessionID names the session an access token belongs to.
+	SessionID string `json:"sid,omitempty"`
+	// Actor is the admin acting as the subject of an impersonation token.
+	Actor *Actor `json:"act,omitempty"`
+	// OIDC carries a sign-in in progress at an external identity provider.
+	OIDC *oidcLogin `json:"oidc,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Actor identifies who is really behind a token issued on someone else's
+// behalf, as in the act claim of RFC 8693.
+type Actor struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name,omitempty"`
+}
+
+// oidcLogin is what the callback of an OpenID Connect sign-in needs to
+// remember from its start.
+type oidcLogin struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
 func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
-	//Create token
-	token := jwt.New(jwt.SigningMethodHS256)
+	accessTokenID, err := newTokenID()
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
 	//Set claims
-	claims := token.Claims.(jwt.MapClaims)
-	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
-	claims["sub"] = fmt.Sprint(user.ID)
-	claims["aud"] = j.Audience
-	claims["iss"] = j.Issuer
-	claims["iat"] = time.Now().UTC().Unix()
-	claims["typ"] = "JWT"
+	claims := j.accessClaims(user, accessTokenID)
+	if user.SessionID != "" {
+		claims["sid"] = user.SessionID
+	}
 
 	//Set expiry for jwt
 	claims["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()
 
 	//Create a signed token
-	signedAccessToken, err := token.SignedString([]byte(j.Secret))
+	signedAccessToken, err := j.sign(claims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
 	//Create a Refresh Token and set Claims
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
+	refreshTokenID, err := newTokenID()
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	refreshExpiresAt := time.Now().UTC().Add(j.RefreshExpiry)
+	refreshTokenClaims := jwt.MapClaims{}
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
+	refreshTokenClaims["jti"] = refreshTokenID
+	refreshTokenClaims["aud"] = j.Audience
+	refreshTokenClaims["iss"] = j.Issuer
 	refreshTokenClaims["iat"] = time.Now().UTC().Unix()
-	refreshTokenClaims["ext"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
+	refreshTokenClaims["typ"] = tokenTypeRefresh
+	refreshTokenClaims["exp"] = refreshExpiresAt.Unix()
 
 	//Create a signed refresh token
-	signedRefreshToken, refErr := refreshToken.SignedString([]byte(j.Secret))
+	signedRefreshToken, refErr := j.sign(refreshTokenClaims)
 	if refErr != nil {
 		return TokenPairs{}, refErr
 	}
@@ -74,10 +142,137 @@ func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
 	var tokenPair TokenPairs
 	tokenPair.Token = signedAccessToken
 	tokenPair.RefreshToken = signedRefreshToken
+	tokenPair.RefreshTokenID = refreshTokenID
+	tokenPair.RefreshExpiresAt = refreshExpiresAt
 
 	return tokenPair, nil
 }
 
+func (j *Auth) accessClaims(user *jwtUser, tokenID string) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["jti"] = tokenID
+	claims["aud"] = j.Audience
+	claims["iss"] = j.Issuer
+	claims["iat"] = time.Now().UTC().Unix()
+	claims["typ"] = tokenTypeAccess
+	claims["roles"] = user.Roles
+	claims["scope"] = strings.Join(user.Permissions, " ")
+	return claims
+}
+
+// GenerateImpersonationToken signs an access token for user on behalf of
+// actor. It names the actor in an RFC 8693 act claim and comes without a
+// refresh token. It returns the token and its jti.
+func (j *Auth) GenerateImpersonationToken(user *jwtUser, actor Actor, expiry time.Duration) (string, string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	claims := j.accessClaims(user, tokenID)
+	claims["act"] = actor
+	claims["exp"] = time.Now().UTC().Add(expiry).Unix()
+	token, err := j.sign(claims)
+	if err != nil {
+		return "", "", err
+	}
+	return token, tokenID, nil
+}
+
+// hmacKeyID is the kid of tokens signed with the shared secret.
+const hmacKeyID = "hs256"
+
+// sign signs claims with the current signing key and names it in the kid header.
+func (j *Auth) sign(claims jwt.MapClaims) (string, error) {
+	if j.Keys == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = hmacKeyID
+		return token.SignedString([]byte(j.Secret))
+	}
+	key := j.Keys.Signing()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// verificationKey picks the key a token claims to be signed with, refusing
+// tokens whose algorithm does not match that key.
+func (j *Auth) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if j.Keys == nil || kid == hmacKeyID {
+		if j.Secret == "" {
+			return nil, errUnknownKey
+		}
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(j.Secret), nil
+	}
+	key, ok := j.Keys.Lookup(kid)
+	if !ok {
+		return nil, errUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// newTokenID returns a random identifier for a token or token family.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GenerateVerificationToken signs a token proving that whoever holds it
+// received mail at email. It stops working once the account's email changes.
+func (j *Auth) GenerateVerificationToken(userID int64, email string, expiry time.Duration) (string, error) {
+	claims := j.userClaims(userID, tokenTypeEmailVerification, expiry)
+	claims["email"] = email
+	return j.sign(claims)
+}
+
+// GenerateMFAToken signs the short-lived token handed out after a correct
+// password when the account still has to pass its second factor.
+func (j *Auth) GenerateMFAToken(userID int64, expiry time.Duration) (string, error) {
+	claims := j.userClaims(userID, tokenTypeMFA, expiry)
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	claims["jti"] = jti
+	return j.sign(claims)
+}
+
+// GenerateOIDCStateToken signs the state of an OpenID Connect sign-in so it
+// can travel in a cookie instead of being stored server-side.
+func (j *Auth) GenerateOIDCStateToken(login oidcLogin, expiry time.Duration) (string, error) {
+	claims := jwt.MapClaims{}
+	claims["aud"] = j.Audience
+	claims["iss"] = j.Issuer
+	claims["iat"] = time.Now().UTC().Unix()
+	claims["typ"] = tokenTypeOIDCState
+	claims["exp"] = time.Now().UTC().Add(expiry).Unix()
+	claims["oidc"] = login
+	return j.sign(claims)
+}
+
+func (j *Auth) userClaims(userID int64, tokenType string, expiry time.Duration) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	claims["sub"] = fmt.Sprint(userID)
+	claims["aud"] = j.Audience
+	claims["iss"] = j.Issuer
+	claims["iat"] = time.Now().UTC().Unix()
+	claims["typ"] = tokenType
+	claims["exp"] = time.Now().UTC().Add(expiry).Unix()
+	return claims
+}
+
 func (j *Auth) GetRefreshCookie(refreshToken string) *http.Cookie {
 	return &http.Cookie{
 		Name:     j.CookieName,
@@ -120,21 +315,54 @@ func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 		return "", nil, errors.New("Invalid Header")
 	}
 	token := headerParts[1]
-	claims := &Claims{}
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	claims, err := j.VerifyAccessToken(r.Context(), token)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// VerifyAccessToken verifies an access token and checks it has not been
+// revoked.
+func (j *Auth) VerifyAccessToken(ctx context.Context, token string) (*Claims, error) {
+	claims, err := j.VerifyToken(token, tokenTypeAccess)
+	if err != nil {
+		return nil, err
+	}
+	if j.Denylist != nil && (claims.ID != "" || claims.SessionID != "") {
+		revoked, err := j.Denylist.IsAccessTokenRevoked(ctx, claims.ID, claims.SessionID)
+		if err != nil {
+			return nil, err
 		}
-		return []byte(j.Secret), nil
-	})
+		if revoked {
+			return nil, errTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// VerifyToken checks the signature, expiry, issuer, audience and type of a
+// token issued by GenerateTokenPair and returns its claims.
+func (j *Auth) VerifyToken(token string, tokenType string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, j.verificationKey)
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "token is expired by") {
-			return "", nil, errors.New("expired token")
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, errors.New("expired token")
 		}
-		return "", nil, err
+		return nil, err
 	}
-	if claims.Issuer != j.Issuer {
-		return "", nil, errors.New("Invalid issuer")
+	if !claims.VerifyExpiresAt(time.Now(), true) {
+		return nil, errors.New("token has no expiry")
 	}
-	return token, claims, nil
+	if !claims.VerifyIssuer(j.Issuer, true) {
+		return nil, errors.New("Invalid issuer")
+	}
+	if !claims.VerifyAudience(j.Audience, true) {
+		return nil, errors.New("Invalid audience")
+	}
+	if claims.Type != tokenType {
+		return nil, fmt.Errorf("expected a %s token", tokenType)
+	}
+	return claims, nil
 }
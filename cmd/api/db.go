@@ -2,10 +2,13 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"log"
+	"strings"
+	"time"
 )
 
 func openDB(dsn string) (*sql.DB, error) {
@@ -28,3 +31,25 @@ func (app *application) connectToDb() (*sql.DB, error) {
 	log.Println("Connected to Postgres!")
 	return connection, nil
 }
+
+// parseTimeouts reads a list like "SearchMovies=5s,AllMovies=2s" into
+// per-operation timeouts for the repository.
+func parseTimeouts(list string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		op, value, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid db timeout %q", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid db timeout %q: %w", entry, err)
+		}
+		timeouts[strings.TrimSpace(op)] = d
+	}
+	return timeouts, nil
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/repository"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeDB is an in-memory repository for handler tests. It follows the same
+// rules as the Postgres queries it stands in for. Methods a test does not
+// need are left to the embedded nil interface and panic when called.
+type fakeDB struct {
+	repository.DatabaseRepo
+
+	mu            sync.Mutex
+	users         map[int]*models.User
+	roles         map[int][]string
+	permissions   map[int][]string
+	refreshTokens map[string]*models.RefreshToken
+	sessions      map[string]*models.Session
+	revoked       map[string]time.Time
+	// verificationSent is when each user was last sent a verification link.
+	verificationSent map[int]time.Time
+	// resetSent is when each user was last sent a password reset link.
+	resetSent map[int]time.Time
+	resets    []models.PasswordReset
+	totp      map[int]*models.TOTP
+	// recoveryCodes maps each user's recovery code hashes to whether they
+	// were used.
+	recoveryCodes map[int]map[string]bool
+	// identities maps provider and subject to the linked identity.
+	identities map[[2]string]*models.Identity
+	apiKeys    map[int]*models.APIKey
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		users:            make(map[int]*models.User),
+		roles:            make(map[int][]string),
+		permissions:      make(map[int][]string),
+		refreshTokens:    make(map[string]*models.RefreshToken),
+		sessions:         make(map[string]*models.Session),
+		revoked:          make(map[string]time.Time),
+		verificationSent: make(map[int]time.Time),
+		resetSent:        make(map[int]time.Time),
+		totp:             make(map[int]*models.TOTP),
+		recoveryCodes:    make(map[int]map[string]bool),
+		identities:       make(map[[2]string]*models.Identity),
+		apiKeys:          make(map[int]*models.APIKey),
+	}
+}
+
+// addUser stores a verified user with the given password and permissions.
+func (db *fakeDB) addUser(email, password string, permissions ...string) *models.User {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	user := &models.User{
+		ID:         int64(len(db.users) + 1),
+		FirstName:  "Test",
+		LastName:   "User",
+		Email:      email,
+		CreatedAt:  now,
+		UpdateAt:   now,
+		VerifiedAt: &now,
+	}
+	err := user.SetPassword(testHashers, password)
+	if err != nil {
+		panic(err)
+	}
+	db.users[int(user.ID)] = user
+	db.roles[int(user.ID)] = []string{"user"}
+	db.permissions[int(user.ID)] = permissions
+	return user
+}
+
+func (db *fakeDB) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return fn(db)
+}
+
+func (db *fakeDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, user := range db.users {
+		if strings.EqualFold(user.Email, email) {
+			u := *user
+			u.TOTPEnabled = db.totp[int(user.ID)].Enabled()
+			return &u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (db *fakeDB) GetUserById(ctx context.Context, id int) (*models.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	user, ok := db.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	u := *user
+	u.TOTPEnabled = db.totp[id].Enabled()
+	return &u, nil
+}
+
+func (db *fakeDB) UserRoles(ctx context.Context, userId int) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.roles[userId], nil
+}
+
+func (db *fakeDB) UserPermissions(ctx context.Context, userId int) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.permissions[userId], nil
+}
+
+// rolePermissions are the permissions of the roles seeded by the schema.
+var rolePermissions = map[string][]string{
+	"viewer": {models.PermissionMoviesRead},
+	"editor": {models.PermissionMoviesRead, models.PermissionMoviesWrite, models.PermissionGenresWrite},
+	"admin": {
+		models.PermissionMoviesRead, models.PermissionMoviesWrite, models.PermissionGenresWrite,
+		models.PermissionUsersWrite, models.PermissionUsersImpersonate, models.PermissionTokensManage,
+	},
+}
+
+func (db *fakeDB) SetUserRoles(ctx context.Context, userId int, roles []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.users[userId]; !ok {
+		return repository.ErrUserNotFound
+	}
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, role := range roles {
+		granted, ok := rolePermissions[role]
+		if !ok {
+			return repository.ErrUnknownRole
+		}
+		for _, permission := range granted {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	db.roles[userId] = roles
+	db.permissions[userId] = permissions
+	return nil
+}
+
+func (db *fakeDB) InsertSession(ctx context.Context, session models.Session) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.sessions[session.ID] = &session
+	return nil
+}
+
+func (db *fakeDB) TouchSession(ctx context.Context, id, userAgent, ip string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if session, ok := db.sessions[id]; ok {
+		session.LastUsedAt = time.Now()
+		session.UserAgent = userAgent
+		session.IP = ip
+	}
+	return nil
+}
+
+func (db *fakeDB) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.refreshTokens[token.ID] = &token
+	return nil
+}
+
+func (db *fakeDB) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	token, ok := db.refreshTokens[id]
+	if !ok {
+		return nil, repository.ErrSessionNotFound
+	}
+	t := *token
+	return &t, nil
+}
+
+func (db *fakeDB) RotateRefreshToken(ctx context.Context, usedId string, next models.RefreshToken) error {
+	db.mu.Lock()
+	used, ok := db.refreshTokens[usedId]
+	if !ok || used.UsedAt != nil || used.RevokedAt != nil {
+		db.mu.Unlock()
+		err := db.RevokeTokenFamily(ctx, next.FamilyID)
+		if err != nil {
+			return err
+		}
+		return repository.ErrRefreshTokenReused
+	}
+	used.UsedAt = &next.CreatedAt
+	used.ReplacedBy = next.ID
+	db.refreshTokens[next.ID] = &next
+	db.mu.Unlock()
+	return nil
+}
+
+func (db *fakeDB) RevokeTokenFamily(ctx context.Context, familyId string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	for _, token := range db.refreshTokens {
+		if token.FamilyID == familyId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) RevokeUserTokens(ctx context.Context, userId int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	for _, token := range db.refreshTokens {
+		if token.UserID == userId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// activeFamily reports whether the family still holds a usable token.
+func (db *fakeDB) activeFamily(familyId string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, token := range db.refreshTokens {
+		if token.FamilyID == familyId && token.UsedAt == nil && token.RevokedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *fakeDB) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.revoked[jti] = expiresAt
+	return nil
+}
+
+func (db *fakeDB) IsAccessTokenRevoked(ctx context.Context, jti, sessionId string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.revoked[jti]; ok {
+		return true, nil
+	}
+	for _, token := range db.refreshTokens {
+		if token.FamilyID == sessionId && token.RevokedAt != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (db *fakeDB) RevokeSession(ctx context.Context, userId int, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	found := false
+	for _, token := range db.refreshTokens {
+		if token.UserID == userId && token.FamilyID == id && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			found = true
+		}
+	}
+	if !found {
+		return repository.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (db *fakeDB) RevokeOtherSessions(ctx context.Context, userId int, keepId string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	for _, token := range db.refreshTokens {
+		if token.UserID == userId && token.FamilyID != keepId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) UpdateUser(ctx context.Context, user models.User) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.users[int(user.ID)]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.Email = user.Email
+	stored.VerifiedAt = user.VerifiedAt
+	stored.UpdateAt = user.UpdateAt
+	return nil
+}
+
+func (db *fakeDB) UpdateUserPassword(ctx context.Context, id int, hash string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.users[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	stored.Password = hash
+	stored.UpdateAt = time.Now()
+	return nil
+}
+
+func (db *fakeDB) TouchVerificationSent(ctx context.Context, id int, interval time.Duration) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	if sent, ok := db.verificationSent[id]; ok && sent.After(now.Add(-interval)) {
+		return false, nil
+	}
+	db.verificationSent[id] = now
+	return true, nil
+}
+
+func (db *fakeDB) TouchPasswordResetSent(ctx context.Context, id int, interval time.Duration) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	if sent, ok := db.resetSent[id]; ok && sent.After(now.Add(-interval)) {
+		return false, nil
+	}
+	db.resetSent[id] = now
+	return true, nil
+}
+
+func (db *fakeDB) InsertPasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.resets = append(db.resets, reset)
+	return nil
+}
+
+// enableTOTP enrolls the user with secret and the recovery code hashes.
+func (db *fakeDB) enableTOTP(userId int, secret string, recoveryCodeHashes ...string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	db.totp[userId] = &models.TOTP{UserID: userId, Secret: secret, EnabledAt: &now, CreatedAt: now}
+	db.recoveryCodes[userId] = make(map[string]bool)
+	for _, hash := range recoveryCodeHashes {
+		db.recoveryCodes[userId][hash] = false
+	}
+}
+
+func (db *fakeDB) GetTOTP(ctx context.Context, userId int) (*models.TOTP, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	enrollment, ok := db.totp[userId]
+	if !ok {
+		return nil, repository.ErrTOTPNotEnrolled
+	}
+	e := *enrollment
+	return &e, nil
+}
+
+func (db *fakeDB) DisableTOTP(ctx context.Context, userId int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.totp, userId)
+	delete(db.recoveryCodes, userId)
+	return nil
+}
+
+func (db *fakeDB) UseTOTPStep(ctx context.Context, userId int, step int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	enrollment, ok := db.totp[userId]
+	if !ok || !enrollment.Enabled() || enrollment.LastStep >= step {
+		return false, nil
+	}
+	enrollment.LastStep = step
+	return true, nil
+}
+
+func (db *fakeDB) UseRecoveryCode(ctx context.Context, userId int, codeHash string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	used, ok := db.recoveryCodes[userId][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	db.recoveryCodes[userId][codeHash] = true
+	return true, nil
+}
+
+func (db *fakeDB) MarkUserVerified(ctx context.Context, id int, email string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	user, ok := db.users[id]
+	if !ok || !strings.EqualFold(user.Email, email) {
+		return repository.ErrInvalidVerification
+	}
+	if user.VerifiedAt == nil {
+		now := time.Now()
+		user.VerifiedAt = &now
+	}
+	return nil
+}
+
+func (db *fakeDB) GetUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	db.mu.Lock()
+	identity, ok := db.identities[[2]string{provider, subject}]
+	if ok {
+		now := time.Now()
+		identity.LastLoginAt = &now
+	}
+	db.mu.Unlock()
+	if !ok {
+		return nil, repository.ErrIdentityNotFound
+	}
+	return db.GetUserById(ctx, identity.UserID)
+}
+
+func (db *fakeDB) LinkIdentity(ctx context.Context, identity models.Identity) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key := [2]string{identity.Provider, identity.Subject}
+	if _, ok := db.identities[key]; ok {
+		panic("identity linked twice")
+	}
+	identity.CreatedAt = time.Now()
+	db.identities[key] = &identity
+	return nil
+}
+
+// addAPIKey stores an API key acting as userId with the given scopes and
+// returns the key to send.
+func (db *fakeDB) addAPIKey(userId int, expiresAt *time.Time, scopes ...string) string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	value, hash, prefix, err := models.GenerateAPIKey()
+	if err != nil {
+		panic(err)
+	}
+	key := &models.APIKey{
+		ID:        len(db.apiKeys) + 1,
+		UserID:    userId,
+		Name:      "test key",
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	db.apiKeys[key.ID] = key
+	return value
+}
+
+func (db *fakeDB) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, key := range db.apiKeys {
+		if key.KeyHash == hash {
+			found := *key
+			return &found, nil
+		}
+	}
+	return nil, repository.ErrAPIKeyNotFound
+}
+
+func (db *fakeDB) InsertAPIKey(ctx context.Context, key *models.APIKey) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored := *key
+	stored.ID = len(db.apiKeys) + 1
+	stored.CreatedAt = time.Now()
+	db.apiKeys[stored.ID] = &stored
+	return stored.ID, nil
+}
+
+func (db *fakeDB) TouchAPIKey(ctx context.Context, id int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if key, ok := db.apiKeys[id]; ok {
+		now := time.Now()
+		key.LastUsedAt = &now
+	}
+	return nil
+}
+
+func (db *fakeDB) RevokeAPIKey(ctx context.Context, id int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key, ok := db.apiKeys[id]
+	if !ok {
+		return repository.ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+	}
+	return nil
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"errors"
-	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
+	"fmt"
+	"go-restapi/inernal/apperror"
 	"go-restapi/inernal/models"
+	"go-restapi/inernal/repository"
+	"go-restapi/inernal/validator"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,13 +28,28 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 	_ = app.writeJSON(w, http.StatusOK, payload)
 }
 
+func (app *application) JWKS(w http.ResponseWriter, r *http.Request) {
+	set := JWKS{Keys: []JWK{}}
+	if app.auth.Keys != nil {
+		set = app.auth.Keys.JWKS()
+	}
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=300")
+	_ = app.writeJSON(w, http.StatusOK, set, headers)
+}
+
 func (app *application) AllMovies(w http.ResponseWriter, r *http.Request) {
-	movies, err := app.DB.AllMovies()
+	query, err := readMovieQuery(r)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
-	_ = app.writeJSON(w, http.StatusOK, movies)
+	movies, total, err := app.DB.AllMovies(r.Context(), query)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, newMoviePage(r, query, movies, total))
 }
 
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
@@ -42,33 +61,93 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
 
-	//validate against database
-	user, err := app.DB.GetUserByEmail(requestPayload.Email)
+	//refuse early while the account or the client is locked out
+	ip := clientIP(r)
+	err = app.loginLimits.check(r.Context(), requestPayload.Email, ip)
 	if err != nil {
-		app.errorJSON(w, errors.New("Invalid Credentials"))
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	//validate against database; only an unknown email or a wrong password
+	//counts as a failed attempt, not an outage
+	user, err := app.DB.GetUserByEmail(r.Context(), requestPayload.Email)
+	if apperror.Is(err, apperror.KindNotFound) {
+		app.loginLimits.fail(r.Context(), requestPayload.Email, ip)
+		app.errorJSON(w, r, apperror.Unauthorized("invalid credentials"))
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
 		return
 	}
 	//check password
-	valid, err := user.PasswordMatches(requestPayload.Password)
-	if err != nil || !valid {
-		app.errorJSON(w, errors.New("Invalid Credentials"))
+	valid, rehash, err := user.VerifyPassword(app.PasswordHashers, requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
 		return
 	}
+	if !valid {
+		app.loginLimits.fail(r.Context(), requestPayload.Email, ip)
+		app.errorJSON(w, r, apperror.Unauthorized("invalid credentials"))
+		return
+	}
+	//hashes made with an older algorithm or cost are upgraded while the plain text is at hand
+	if rehash {
+		app.rehashPassword(r.Context(), user, requestPayload.Password)
+	}
+	if !user.Verified() {
+		app.errorJSON(w, r, errEmailNotVerified)
+		return
+	}
+	//accounts with a second factor get an mfa token to finish signing in at /authenticate/2fa
+	if user.TOTPEnabled {
+		app.mfaChallenge(w, r, user)
+		return
+	}
+
+	app.loginLimits.succeed(r.Context(), user.Email)
+	app.issueSession(w, r, user)
+}
+
+// issueSession signs a token pair for user, starts a new refresh token family
+// and sends both tokens back.
+func (app *application) issueSession(w http.ResponseWriter, r *http.Request, user *models.User) {
 	//create a jwt user
-	u := jwtUser{
-		ID:        user.ID,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
+	u, err := app.jwtUserFor(r.Context(), user)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	//every login starts a new session, a new refresh token family
+	u.SessionID, err = newTokenID()
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
 	}
 
 	//generate tokens
-	tokens, err := app.auth.GenerateTokenPair(&u)
+	tokens, err := app.auth.GenerateTokenPair(u)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+
+	session := newSession(r, u.SessionID, int(user.ID))
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		err := repo.InsertSession(r.Context(), session)
+		if err != nil {
+			return err
+		}
+		return repo.InsertRefreshToken(r.Context(), refreshRecord(tokens, int(user.ID), u.SessionID))
+	})
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
 
@@ -78,86 +157,161 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
-	for _, cookie := range r.Cookies() {
-		if cookie.Name == app.auth.CookieName {
-			claims := &Claims{}
-			refrestoken := cookie.Value
-
-			_, err := jwt.ParseWithClaims(refrestoken, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(app.JWTSecret), nil
-			})
-			if err != nil {
-				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
-				return
-			}
-
-			userID, err := strconv.Atoi(claims.Subject)
-			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
-			user, err := app.DB.GetUserById(userID)
-			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
-
-			u := jwtUser{
-				ID:        user.ID,
-				FirstName: user.FirstName,
-				LastName:  user.LastName,
-			}
-
-			tokenPairs, err := app.auth.GenerateTokenPair(&u)
-			if err != nil {
-				app.errorJSON(w, errors.New("Error generating token"), http.StatusUnauthorized)
-				return
-			}
-			http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
-			app.writeJSON(w, http.StatusOK, tokenPairs)
+	claims, err := app.parseRefreshCookie(r)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Unauthorized("unauthorized"))
+		return
+	}
+
+	stored, err := app.DB.GetRefreshToken(r.Context(), claims.ID)
+	if err != nil {
+		if apperror.Is(err, apperror.KindNotFound) {
+			app.errorJSON(w, r, apperror.Unauthorized("unauthorized"))
+			return
+		}
+		app.errorJSON(w, r, err)
+		return
+	}
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) || claims.Subject != strconv.Itoa(stored.UserID) {
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+		app.errorJSON(w, r, apperror.Unauthorized("refresh token is no longer valid"))
+		return
+	}
+
+	user, err := app.DB.GetUserById(r.Context(), stored.UserID)
+	if err != nil {
+		if apperror.Is(err, apperror.KindNotFound) {
+			err = apperror.Unauthorized("unknown user")
+		}
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	//create a jwt user
+	u, err := app.jwtUserFor(r.Context(), user)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	u.SessionID = stored.FamilyID
+
+	tokenPairs, err := app.auth.GenerateTokenPair(u)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+
+	//refresh tokens are single use; presenting a spent one revokes the family
+	err = app.DB.RotateRefreshToken(r.Context(), stored.ID, refreshRecord(tokenPairs, stored.UserID, stored.FamilyID))
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
 		}
+		app.errorJSON(w, r, err)
+		return
 	}
+	err = app.DB.TouchSession(r.Context(), stored.FamilyID, userAgent(r), clientIP(r))
+	if err != nil {
+		log.Println("updating session:", err)
+	}
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
+	app.writeJSON(w, http.StatusOK, tokenPairs)
 }
 
 func (app *application) logout(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+
+	//revoke the session server-side so a copied cookie cannot be used either
+	claims, err := app.parseRefreshCookie(r)
+	if err == nil {
+		stored, err := app.DB.GetRefreshToken(r.Context(), claims.ID)
+		if err == nil {
+			err = app.DB.RevokeTokenFamily(r.Context(), stored.FamilyID)
+		}
+		if err != nil && !apperror.Is(err, apperror.KindNotFound) {
+			app.errorJSON(w, r, err)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// parseRefreshCookie reads and verifies the refresh token cookie.
+func (app *application) parseRefreshCookie(r *http.Request) (*Claims, error) {
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err != nil {
+		return nil, err
+	}
+	return app.auth.VerifyToken(cookie.Value, tokenTypeRefresh)
+}
+
+// refreshRecord builds the server-side record for the refresh token in tokens.
+func refreshRecord(tokens TokenPairs, userID int, familyID string) models.RefreshToken {
+	return models.RefreshToken{
+		ID:        tokens.RefreshTokenID,
+		UserID:    userID,
+		FamilyID:  familyID,
+		ExpiresAt: tokens.RefreshExpiresAt,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (app *application) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	err = app.DB.RevokeUserTokens(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "sessions revoked!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
 func (app *application) MovieCatalog(w http.ResponseWriter, r *http.Request) {
-	movies, err := app.DB.AllMovies()
+	query, err := readMovieQuery(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	movies, total, err := app.DB.AllMovies(r.Context(), query)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
-	_ = app.writeJSON(w, http.StatusOK, movies)
+	_ = app.writeJSON(w, http.StatusOK, newMoviePage(r, query, movies, total))
 }
 
 func (app *application) GetMovie(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	movieId, err := strconv.Atoi(id)
+	movieId, err := readIDParam(r)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
-	movie, err := app.DB.OneMovie(movieId)
+	movie, err := app.DB.OneMovie(r.Context(), movieId)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
 	_ = app.writeJSON(w, http.StatusOK, movie)
 }
 
 func (app *application) MovieForEdit(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	movieId, err := strconv.Atoi(id)
+	movieId, err := readIDParam(r)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
-	movie, genres, err := app.DB.OneMovieForEdit(movieId)
+	movie, genres, err := app.DB.OneMovieForEdit(r.Context(), movieId)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
 	var payload = struct {
@@ -172,9 +326,9 @@ func (app *application) MovieForEdit(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) AllGenres(w http.ResponseWriter, r *http.Request) {
-	genres, err := app.DB.AllGenres()
+	genres, err := app.DB.AllGenres(r.Context())
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
 	_ = app.writeJSON(w, http.StatusOK, genres)
@@ -184,20 +338,74 @@ func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request) {
 	var movie models.Movie
 	err := app.readJSON(w, r, &movie)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
+		return
+	}
+	err = app.validateMovie(r, &movie)
+	if err != nil {
+		app.errorJSON(w, r, err)
 		return
 	}
 	movie.Image = "/8Z8dptJEypuLoOQro1WugD855YE.jpg"
 	movie.CreatedAt = time.Now()
 	movie.UpdatedAt = time.Now()
-	newID, err := app.DB.InsertMovie(movie)
+	movie.CreatedBy = app.principal(r).UserID
+	movie.UpdatedBy = app.principal(r).UserID
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		newID, err := repo.InsertMovie(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+		return repo.UpdateMovieGenres(r.Context(), newID, movie.GenresArray)
+	})
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
 		return
 	}
-	err = app.DB.UpdateMovieGenres(newID, movie.GenresArray)
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie updated!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
+	movieId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	movie, _, err := app.DB.OneMovieForEdit(r.Context(), movieId)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	//fields missing from the payload keep their current values, so PATCH works too
+	err = app.readJSON(w, r, movie)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	movie.ID = movieId
+	err = app.validateMovie(r, movie)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	movie.UpdatedAt = time.Now()
+	movie.UpdatedBy = app.principal(r).UserID
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		err := repo.UpdateMovie(r.Context(), *movie)
+		if err != nil {
+			return err
+		}
+		return repo.UpdateMovieGenres(r.Context(), movieId, movie.GenresArray)
+	})
+	if err != nil {
+		app.errorJSON(w, r, err)
 		return
 	}
 	resp := JSONResponse{
@@ -207,3 +415,152 @@ func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request) {
 
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
+
+func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
+	movieId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	err = app.DB.DeleteMovie(r.Context(), movieId)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie deleted!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (app *application) SearchMovies(w http.ResponseWriter, r *http.Request) {
+	term := strings.TrimSpace(r.URL.Query().Get("q"))
+	if term == "" {
+		app.errorJSON(w, r, apperror.BadRequest("search term q is required"))
+		return
+	}
+	limit := defaultPageSize
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			app.errorJSON(w, r, apperror.BadRequest(fmt.Sprintf("limit must be between 1 and %d", maxPageSize)))
+			return
+		}
+	}
+	results, err := app.DB.SearchMovies(r.Context(), term, limit)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if results == nil {
+		results = []*models.MovieSearchResult{}
+	}
+	_ = app.writeJSON(w, http.StatusOK, results)
+}
+
+func (app *application) InsertGenre(w http.ResponseWriter, r *http.Request) {
+	var genre models.Genre
+	err := app.readJSON(w, r, &genre)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	genre.Genre = strings.TrimSpace(genre.Genre)
+	err = genre.Validate()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	genre.CreatedAt = time.Now()
+	genre.UpdatedAt = time.Now()
+	newID, err := app.DB.InsertGenre(r.Context(), genre)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "genre created!",
+		Data:    newID,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+func (app *application) UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	genreId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	var genre models.Genre
+	err = app.readJSON(w, r, &genre)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	genre.Genre = strings.TrimSpace(genre.Genre)
+	err = genre.Validate()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	genre.ID = genreId
+	genre.UpdatedAt = time.Now()
+	err = app.DB.UpdateGenre(r.Context(), genre)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "genre updated!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (app *application) DeleteGenre(w http.ResponseWriter, r *http.Request) {
+	genreId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	reassignTo := 0
+	if v := r.URL.Query().Get("reassign_to"); v != "" {
+		reassignTo, err = strconv.Atoi(v)
+		if err != nil {
+			app.errorJSON(w, r, apperror.BadRequest("reassign_to must be a genre id"))
+			return
+		}
+	}
+	err = app.DB.DeleteGenre(r.Context(), genreId, reassignTo)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "genre deleted!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// validateMovie checks the movie's own fields and that every genre it
+// references exists, reporting all problems at once.
+func (app *application) validateMovie(r *http.Request, movie *models.Movie) error {
+	v := validator.New()
+	movie.Check(v)
+	missing, err := app.DB.MissingGenreIds(r.Context(), movie.GenresArray)
+	if err != nil {
+		return err
+	}
+	for _, id := range missing {
+		v.AddError("genres_array", fmt.Sprintf("genre %d does not exist", id))
+	}
+	return v.Err()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-restapi/inernal/models"
+	"net/http"
+	"testing"
+)
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	_, first := app.signIn(t, "ann@example.com", "correct horse")
+
+	w := app.do(t, testRequest{method: "POST", path: "/refresh", cookie: first})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: status %d: %s", w.Code, w.Body)
+	}
+	second := refreshCookie(t, app, w)
+	if second.Value == first.Value {
+		t.Fatal("refresh returned the same refresh token")
+	}
+
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: second})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh with the rotated token: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	_, first := app.signIn(t, "ann@example.com", "correct horse")
+
+	w := app.do(t, testRequest{method: "POST", path: "/refresh", cookie: first})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: status %d: %s", w.Code, w.Body)
+	}
+	second := refreshCookie(t, app, w)
+
+	//replaying the spent token is refused and clears the cookie
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: first})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("reused token: status %d, want 401", w.Code)
+	}
+	cleared := false
+	for _, cookie := range w.Result().Cookies() {
+		cleared = cleared || (cookie.Name == app.auth.CookieName && cookie.MaxAge < 0)
+	}
+	if !cleared {
+		t.Error("reused token response does not clear the refresh cookie")
+	}
+
+	//and the token it was replaced by is revoked along with the family
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: second})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("token from a revoked family: status %d, want 401", w.Code)
+	}
+	claims, err := app.auth.VerifyToken(first.Value, tokenTypeRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.GetRefreshToken(context.Background(), claims.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.activeFamily(stored.FamilyID) {
+		t.Error("family is still active after reuse")
+	}
+}
+
+func TestLogoutRevokesFamily(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	_, cookie := app.signIn(t, "ann@example.com", "correct horse")
+
+	w := app.do(t, testRequest{method: "GET", path: "/logout", cookie: cookie})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("logout: status %d: %s", w.Code, w.Body)
+	}
+
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: cookie})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh after logout: status %d, want 401", w.Code)
+	}
+}
+
+func TestAdminRevokesAllUserSessions(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("admin@example.com", "admin password", models.PermissionUsersWrite)
+	user := db.addUser("ann@example.com", "correct horse")
+	adminToken, adminCookie := app.signIn(t, "admin@example.com", "admin password")
+	laptopToken, laptop := app.signIn(t, "ann@example.com", "correct horse")
+	_, phone := app.signIn(t, "ann@example.com", "correct horse")
+
+	w := app.do(t, testRequest{
+		method: "DELETE",
+		path:   fmt.Sprintf("/admin/users/%d/sessions", user.ID),
+		token:  adminToken,
+	})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("revoking sessions: status %d: %s", w.Code, w.Body)
+	}
+
+	for name, cookie := range map[string]*http.Cookie{"laptop": laptop, "phone": phone} {
+		w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: cookie})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("refresh from %s: status %d, want 401", name, w.Code)
+		}
+	}
+	w = app.do(t, testRequest{method: "GET", path: "/me/", token: laptopToken})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("access token of a revoked session: status %d, want 401", w.Code)
+	}
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: adminCookie})
+	if w.Code != http.StatusOK {
+		t.Errorf("other users' sessions were revoked too: status %d", w.Code)
+	}
+}
+
+func TestRevokeAllRequiresPermission(t *testing.T) {
+	app, db := newTestApp(t)
+	user := db.addUser("ann@example.com", "correct horse")
+	token, _ := app.signIn(t, "ann@example.com", "correct horse")
+
+	w := app.do(t, testRequest{
+		method: "DELETE",
+		path:   fmt.Sprintf("/admin/users/%d/sessions", user.ID),
+		token:  token,
+	})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403", w.Code)
+	}
+}
+
+func TestTokenTypesAreNotInterchangeable(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	access, refresh := app.signIn(t, "ann@example.com", "correct horse")
+
+	w := app.do(t, testRequest{
+		method: "POST",
+		path:   "/refresh",
+		cookie: &http.Cookie{Name: app.auth.CookieName, Value: access},
+	})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("access token at /refresh: status %d, want 401", w.Code)
+	}
+
+	w = app.do(t, testRequest{method: "GET", path: "/me", token: refresh.Value})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token as bearer token: status %d, want 401", w.Code)
+	}
+
+	w = app.do(t, testRequest{method: "GET", path: "/me", token: access})
+	if w.Code != http.StatusOK {
+		t.Errorf("access token as bearer token: status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"go-restapi/inernal/mailer"
+	"go-restapi/inernal/password"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withURLParams attaches chi route parameters to r, as the router would.
+func withURLParams(r *http.Request, params map[string]string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for key, value := range params {
+		rctx.URLParams.Add(key, value)
+	}
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+// testHashers stores passwords with parameters cheap enough to keep the
+// tests fast.
+var testHashers = &password.Hashers{
+	Preferred: password.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	Accepted:  []password.Hasher{password.Bcrypt{Cost: 4}},
+}
+
+// newTestApp returns an application backed by a fakeDB, signing tokens with
+// HS256 and counting failed sign-ins in memory.
+func newTestApp(t *testing.T) (*application, *fakeDB) {
+	t.Helper()
+	db := newFakeDB()
+	app := &application{
+		DB:              db,
+		JWTIssuer:       "example.com",
+		APIURL:          "http://api.example.com",
+		Mailer:          &fakeMailer{sent: make(chan mailer.Message, 10)},
+		mfaRoles:        map[string]bool{},
+		PasswordHashers: testHashers,
+	}
+	app.auth = Auth{
+		Issuer:        "example.com",
+		Audience:      "example.com",
+		Secret:        "test-secret",
+		TokenExpiry:   15 * time.Minute,
+		RefreshExpiry: 24 * time.Hour,
+		CookiePath:    "/",
+		CookieDomain:  "localhost",
+		CookieName:    "__Host-refresh_token",
+		Denylist:      db,
+	}
+	var err error
+	app.loginLimits, err = LockoutConfig{
+		Store:            "memory",
+		AccountThreshold: 5,
+		IPThreshold:      20,
+		BaseDelay:        time.Minute,
+		MaxDelay:         time.Hour,
+	}.limits(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, db
+}
+
+// testRequest describes one request sent through the router.
+type testRequest struct {
+	method string
+	path   string
+	body   string
+	token  string
+	cookie *http.Cookie
+	header http.Header
+}
+
+func (app *application) do(t *testing.T, req testRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	var body io.Reader
+	if req.body != "" {
+		body = strings.NewReader(req.body)
+	}
+	r := httptest.NewRequest(req.method, req.path, body)
+	if req.token != "" {
+		r.Header.Set("Authorization", "Bearer "+req.token)
+	}
+	if req.cookie != nil {
+		r.AddCookie(req.cookie)
+	}
+	for name, values := range req.header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+	return w
+}
+
+// signIn authenticates with email and password and returns the access token
+// and the refresh token cookie.
+func (app *application) signIn(t *testing.T, email, password string) (string, *http.Cookie) {
+	t.Helper()
+	w := app.do(t, testRequest{
+		method: "POST",
+		path:   "/authenticate",
+		body:   `{"email":"` + email + `","password":"` + password + `"}`,
+	})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("signing in: status %d: %s", w.Code, w.Body)
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	decode(t, w, &tokens)
+	return tokens.AccessToken, refreshCookie(t, app, w)
+}
+
+// refreshCookie returns the refresh token cookie set by a response.
+func refreshCookie(t *testing.T, app *application, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == app.auth.CookieName && cookie.Value != "" {
+			return &http.Cookie{Name: cookie.Name, Value: cookie.Value}
+		}
+	}
+	t.Fatalf("response sets no refresh token cookie")
+	return nil
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+}
+
+// fakeMailer hands sent messages to the test.
+type fakeMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent <- msg
+	return nil
+}
+
+// mailTo waits for the next message sent by app and checks its recipient.
+func mailTo(t *testing.T, app *application, to string) mailer.Message {
+	t.Helper()
+	select {
+	case msg := <-app.Mailer.(*fakeMailer).sent:
+		if msg.To != to {
+			t.Fatalf("mail sent to %s, want %s", msg.To, to)
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no mail sent to %s", to)
+		return mailer.Message{}
+	}
+}
+
+// noMail checks that app sends no message.
+func noMail(t *testing.T, app *application) {
+	t.Helper()
+	select {
+	case msg := <-app.Mailer.(*fakeMailer).sent:
+		t.Fatalf("unexpected mail to %s: %s", msg.To, msg.Subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const impersonationTTL = 10 * time.Minute
+
+// Impersonate issues a short-lived access token to act as another user, for
+// support staff reproducing what that user sees. Every token is recorded
+// before it is handed out.
+func (app *application) Impersonate(w http.ResponseWriter, r *http.Request) {
+	principal := app.principal(r)
+	userId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	var requestPayload struct {
+		Reason string `json:"reason"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(strings.TrimSpace(requestPayload.Reason) != "", "reason", "must be provided")
+	v.Check(userId != principal.UserID, "id", "must not be your own account")
+	err = v.Err()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	user, err := app.DB.GetUserById(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	u, err := app.jwtUserFor(r.Context(), user)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	actor := Actor{
+		Subject: fmt.Sprint(principal.UserID),
+		Name:    principal.Name,
+	}
+	token, tokenID, err := app.auth.GenerateImpersonationToken(u, actor, impersonationTTL)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+
+	now := time.Now()
+	impersonation := models.Impersonation{
+		ActorID:   &principal.UserID,
+		SubjectID: &userId,
+		TokenID:   tokenID,
+		Reason:    strings.TrimSpace(requestPayload.Reason),
+		IP:        clientIP(r),
+		UserAgent: userAgent(r),
+		ExpiresAt: now.Add(impersonationTTL),
+		CreatedAt: now,
+	}
+	err = app.DB.InsertImpersonation(r.Context(), impersonation)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	resp := struct {
+		AccessToken string    `json:"access_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}{
+		AccessToken: token,
+		ExpiresAt:   impersonation.ExpiresAt,
+	}
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+func (app *application) UserImpersonations(w http.ResponseWriter, r *http.Request) {
+	userId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	impersonations, err := app.DB.UserImpersonations(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, impersonations)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"os"
+	"sync"
+)
+
+var errUnknownKey = errors.New("token signed with an unknown key")
+
+// signingKey is one asymmetric key known to the server. Private is nil for
+// keys that are only kept around to verify tokens they signed earlier.
+type signingKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// KeySet holds the key that signs new tokens and every key that may still
+// verify them. Rotating keys means pointing SigningKeyFile at the new key,
+// moving the old one into VerifyKeyFiles and calling Reload; once tokens
+// signed by the old key have expired it can be dropped from the list.
+type KeySet struct {
+	SigningKeyFile string
+	VerifyKeyFiles []string
+
+	mu      sync.RWMutex
+	signing *signingKey
+	keys    map[string]*signingKey
+}
+
+func NewKeySet(signingKeyFile string, verifyKeyFiles []string) (*KeySet, error) {
+	ks := &KeySet{
+		SigningKeyFile: signingKeyFile,
+		VerifyKeyFiles: verifyKeyFiles,
+	}
+	err := ks.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Reload reads the key files again. The previous keys stay in use if any of
+// the files cannot be loaded.
+func (ks *KeySet) Reload() error {
+	signing, err := loadKeyFile(ks.SigningKeyFile)
+	if err != nil {
+		return err
+	}
+	if signing.Private == nil {
+		return fmt.Errorf("%s: signing key must be a private key", ks.SigningKeyFile)
+	}
+	keys := map[string]*signingKey{signing.ID: signing}
+	for _, file := range ks.VerifyKeyFiles {
+		key, err := loadKeyFile(file)
+		if err != nil {
+			return err
+		}
+		keys[key.ID] = key
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.signing = signing
+	ks.keys = keys
+	return nil
+}
+
+func (ks *KeySet) Signing() *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.signing
+}
+
+func (ks *KeySet) Lookup(kid string) (*signingKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key that may verify tokens.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		set.Keys = append(set.Keys, publicJWK(key))
+	}
+	return set
+}
+
+func publicJWK(key *signingKey) JWK {
+	jwk := JWK{
+		KeyID:     key.ID,
+		Use:       "sig",
+		Algorithm: key.Method.Alg(),
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = b64(pub)
+	}
+	return jwk
+}
+
+// loadKeyFile reads a PEM encoded RSA, P-256 ECDSA or Ed25519 key, private or
+// public. The key id is derived from the public key so it is stable across
+// restarts and identical on every instance.
+func loadKeyFile(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", file)
+	}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	key := &signingKey{}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.Private = signer
+		key.Public = signer.Public()
+	} else {
+		key.Public = parsed
+	}
+
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s: only P-256 ECDSA keys are supported", file)
+		}
+		key.Method = jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", file, key.Public)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	sum := sha256.Sum256(der)
+	key.ID = base64.RawURLEncoding.EncodeToString(sum[:12])
+	return key, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// writeKey writes key to a PEM file, as a private key or, with public set,
+// only its public half.
+func writeKey(t *testing.T, file string, key crypto.Signer, public bool) {
+	t.Helper()
+	var block *pem.Block
+	if public {
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// keyID is the kid a key is published under.
+func keyID(t *testing.T, file string) string {
+	t.Helper()
+	key, err := loadKeyFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.ID
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		key    crypto.Signer
+		public bool
+		method jwt.SigningMethod
+	}{
+		{"rsa", rsaKey, false, jwt.SigningMethodRS256},
+		{"ec", newECKey(t), false, jwt.SigningMethodES256},
+		{"ed25519", edKey, false, jwt.SigningMethodEdDSA},
+		{"rsa public", rsaKey, true, jwt.SigningMethodRS256},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(dir, tt.name+".pem")
+		writeKey(t, file, tt.key, tt.public)
+		key, err := loadKeyFile(file)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if key.Method != tt.method || (key.Private == nil) != tt.public {
+			t.Errorf("%s: method %v, private %v", tt.name, key.Method.Alg(), key.Private != nil)
+		}
+	}
+	//the kid depends on the public key only, so both halves share it
+	if keyID(t, filepath.Join(dir, "rsa.pem")) != keyID(t, filepath.Join(dir, "rsa public.pem")) {
+		t.Error("private and public halves have different key ids")
+	}
+
+	writeKey(t, filepath.Join(dir, "p384.pem"), p384, false)
+	_ = os.WriteFile(filepath.Join(dir, "garbage.pem"), []byte("not a key"), 0o600)
+	for _, name := range []string{"p384.pem", "garbage.pem", "missing.pem"} {
+		if _, err := loadKeyFile(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s loaded", name)
+		}
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	dir := t.TempDir()
+	signing := filepath.Join(dir, "signing.pem")
+	previous := filepath.Join(dir, "previous.pem")
+	writeKey(t, signing, newECKey(t), false)
+	writeKey(t, previous, newECKey(t), true)
+
+	ks, err := NewKeySet(signing, []string{previous})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks.Signing().ID != keyID(t, signing) {
+		t.Error("signing key is not the one from the signing key file")
+	}
+	for _, file := range []string{signing, previous} {
+		if _, ok := ks.Lookup(keyID(t, file)); !ok {
+			t.Errorf("%s not in the key set", filepath.Base(file))
+		}
+	}
+	if len(ks.JWKS().Keys) != 2 {
+		t.Errorf("JWKS has %d keys, want 2", len(ks.JWKS().Keys))
+	}
+
+	//a public key cannot sign
+	_, err = NewKeySet(previous, nil)
+	if err == nil {
+		t.Error("public key accepted as signing key")
+	}
+
+	//a broken file leaves the loaded keys in place
+	before := ks.Signing().ID
+	_ = os.WriteFile(signing, []byte("truncated"), 0o600)
+	if err := ks.Reload(); err == nil {
+		t.Fatal("Reload accepted a broken key file")
+	}
+	if ks.Signing().ID != before {
+		t.Error("failed reload replaced the signing key")
+	}
+}
+
+// newKeyTestApp returns a test application signing with the key in the
+// signing file and also verifying with the keys in the verify files.
+func newKeyTestApp(t *testing.T, signing string, verify ...string) (*application, *fakeDB) {
+	t.Helper()
+	app, db := newTestApp(t)
+	keys, err := NewKeySet(signing, verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.auth.Keys = keys
+	return app, db
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	app, _ := newTestApp(t)
+	w := app.do(t, testRequest{method: "GET", path: "/.well-known/jwks.json"})
+	var set JWKS
+	decode(t, w, &set)
+	if w.Code != http.StatusOK || len(set.Keys) != 0 {
+		t.Fatalf("HS256 only: status %d, %d keys", w.Code, len(set.Keys))
+	}
+
+	dir := t.TempDir()
+	signing := filepath.Join(dir, "signing.pem")
+	previous := filepath.Join(dir, "previous.pem")
+	writeKey(t, signing, newECKey(t), false)
+	writeKey(t, previous, newECKey(t), false)
+	app, _ = newKeyTestApp(t, signing, previous)
+
+	w = app.do(t, testRequest{method: "GET", path: "/.well-known/jwks.json"})
+	set = JWKS{}
+	decode(t, w, &set)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") == "" {
+		t.Fatalf("status %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	kids := map[string]bool{}
+	for _, jwk := range set.Keys {
+		kids[jwk.KeyID] = true
+		if jwk.KeyType != "EC" || jwk.Algorithm != "ES256" || jwk.Use != "sig" || jwk.X == "" || jwk.Y == "" {
+			t.Errorf("unexpected key %+v", jwk)
+		}
+		if jwk.KeyID == hmacKeyID {
+			t.Error("the HS256 secret is published")
+		}
+	}
+	if len(kids) != 2 || !kids[keyID(t, signing)] || !kids[keyID(t, previous)] {
+		t.Errorf("published keys %v", kids)
+	}
+	if strings.Contains(w.Body.String(), `"d"`) {
+		t.Error("private key material published")
+	}
+}
+
+func TestKeyRotationOnSignal(t *testing.T) {
+	dir := t.TempDir()
+	signing := filepath.Join(dir, "signing.pem")
+	previous := filepath.Join(dir, "previous.pem")
+	oldKey, newKey := newECKey(t), newECKey(t)
+	writeKey(t, signing, oldKey, false)
+	writeKey(t, previous, oldKey, true)
+	app, db := newKeyTestApp(t, signing, previous)
+	db.addUser("ann@example.com", "correct horse")
+	oldToken, oldCookie := app.signIn(t, "ann@example.com", "correct horse")
+
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		app.reloadKeys(signals)
+		close(done)
+	}()
+	defer func() {
+		close(signals)
+		<-done
+	}()
+	reload := func() {
+		t.Helper()
+		signals <- syscall.SIGHUP
+		//signals is unbuffered, so this second send only goes through once
+		//the first reload has finished
+		signals <- syscall.SIGHUP
+	}
+
+	//rotate: the new key signs, the old one only verifies
+	writeKey(t, signing, newKey, false)
+	reload()
+	if app.auth.Keys.Signing().ID != keyID(t, signing) {
+		t.Fatal("signing key not rotated")
+	}
+	newToken, _ := app.signIn(t, "ann@example.com", "correct horse")
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		w := app.do(t, testRequest{method: "GET", path: "/me/", token: token})
+		if w.Code != http.StatusOK {
+			t.Errorf("%s token after rotation: status %d", name, w.Code)
+		}
+	}
+	w := app.do(t, testRequest{method: "POST", path: "/refresh", cookie: oldCookie})
+	if w.Code != http.StatusOK {
+		t.Errorf("refresh token signed by the old key: status %d", w.Code)
+	}
+
+	//retire the old key
+	writeKey(t, previous, newKey, true)
+	reload()
+	_, err := app.auth.VerifyToken(oldToken, tokenTypeAccess)
+	if !errors.Is(err, errUnknownKey) {
+		t.Errorf("token of a retired key: err = %v, want errUnknownKey", err)
+	}
+	if _, err := app.auth.VerifyToken(newToken, tokenTypeAccess); err != nil {
+		t.Errorf("token of the current key: %v", err)
+	}
+}
+
+func TestHS256TokensSurviveSwitchToSigningKey(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	hsToken, hsCookie := app.signIn(t, "ann@example.com", "correct horse")
+
+	dir := t.TempDir()
+	signing := filepath.Join(dir, "signing.pem")
+	writeKey(t, signing, newECKey(t), false)
+	keys, err := NewKeySet(signing, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.auth.Keys = keys
+
+	w := app.do(t, testRequest{method: "GET", path: "/me/", token: hsToken})
+	if w.Code != http.StatusOK {
+		t.Errorf("HS256 access token after the switch: status %d", w.Code)
+	}
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: hsCookie})
+	if w.Code != http.StatusOK {
+		t.Fatalf("HS256 refresh token after the switch: status %d", w.Code)
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	decode(t, w, &tokens)
+	token, _, err := jwt.NewParser().ParseUnverified(tokens.AccessToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != keys.Signing().ID {
+		t.Errorf("refreshed token signed with kid %v, want the signing key", token.Header["kid"])
+	}
+
+	//an HS256 token naming an asymmetric key is refused
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"typ": tokenTypeAccess})
+	forged.Header["kid"] = keys.Signing().ID
+	raw, _ := forged.SignedString([]byte(app.auth.Secret))
+	if _, err := app.auth.VerifyToken(raw, tokenTypeAccess); err == nil {
+		t.Error("HS256 token accepted under an asymmetric kid")
+	}
+
+	//retiring the secret
+	app.auth.Secret = ""
+	_, err = app.auth.VerifyToken(hsToken, tokenTypeAccess)
+	if !errors.Is(err, errUnknownKey) {
+		t.Errorf("HS256 token after retiring the secret: err = %v, want errUnknownKey", err)
+	}
+}
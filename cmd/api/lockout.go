@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/lockout"
+	"go-restapi/inernal/models"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+type LockoutConfig struct {
+	Store            string
+	AccountThreshold int
+	IPThreshold      int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+}
+
+// loginLimits throttles sign-in attempts per account and per source IP.
+type loginLimits struct {
+	account *lockout.Limiter
+	ip      *lockout.Limiter
+	// resetIP counts password reset requests per source IP, whether or not
+	// they name an account.
+	resetIP *lockout.Limiter
+}
+
+func (c LockoutConfig) limits(db lockout.Store) (loginLimits, error) {
+	var store lockout.Store
+	switch c.Store {
+	case "postgres":
+		store = db
+	case "memory":
+		store = lockout.NewMemoryStore()
+	default:
+		return loginLimits{}, fmt.Errorf("unknown lockout store %q", c.Store)
+	}
+	policy := func(threshold int) lockout.Policy {
+		return lockout.Policy{
+			Threshold: threshold,
+			BaseDelay: c.BaseDelay,
+			MaxDelay:  c.MaxDelay,
+			Window:    c.MaxDelay,
+		}
+	}
+	return loginLimits{
+		account: &lockout.Limiter{Store: store, Policy: policy(c.AccountThreshold), Prefix: "account:"},
+		ip:      &lockout.Limiter{Store: store, Policy: policy(c.IPThreshold), Prefix: "ip:"},
+		resetIP: &lockout.Limiter{Store: store, Policy: policy(c.IPThreshold), Prefix: "reset-ip:"},
+	}, nil
+}
+
+// check refuses the attempt with a 429 while the account or the IP is locked.
+func (l loginLimits) check(ctx context.Context, email, ip string) error {
+	wait, err := l.account.Check(ctx, models.NormalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	ipWait, err := l.ip.Check(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return apperror.TooManyRequests("too many failed sign-in attempts, try again later", wait).WithCode("login_locked")
+	}
+	return nil
+}
+
+// fail counts a failed attempt. The caller still reports the original
+// failure; only the next attempt is refused.
+func (l loginLimits) fail(ctx context.Context, email, ip string) {
+	_, err := l.account.Fail(ctx, models.NormalizeEmail(email))
+	if err != nil {
+		log.Println("recording failed sign-in:", err)
+	}
+	_, err = l.ip.Fail(ctx, ip)
+	if err != nil {
+		log.Println("recording failed sign-in:", err)
+	}
+}
+
+// succeed clears the account's failures. The IP count is left alone so one
+// valid account cannot be used to keep guessing others from the same address.
+func (l loginLimits) succeed(ctx context.Context, email string) {
+	err := l.account.Reset(ctx, models.NormalizeEmail(email))
+	if err != nil {
+		log.Println("resetting failed sign-ins:", err)
+	}
+}
+
+// clientIP is the address the request came from, without the port. Behind a
+// reverse proxy it is only the client's when the proxy is listed in
+// -trusted-proxies; otherwise every client shares the proxy's address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (app *application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	user, err := app.DB.GetUserById(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	err = app.loginLimits.account.Reset(r.Context(), models.NormalizeEmail(user.Email))
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account unlocked!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/repository"
+	"net/http"
+	"testing"
+)
+
+// outageDB fails user lookups with err while it is set.
+type outageDB struct {
+	*fakeDB
+	err error
+}
+
+func (db *outageDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if db.err != nil {
+		return nil, db.err
+	}
+	return db.fakeDB.GetUserByEmail(ctx, email)
+}
+
+func authenticateBody(email, password string) string {
+	return `{"email":"` + email + `","password":"` + password + `"}`
+}
+
+func TestAuthenticateLocksAfterFailures(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+
+	for i := 0; i < 5; i++ {
+		w := app.do(t, testRequest{method: "POST", path: "/authenticate", body: authenticateBody("ann@example.com", "wrong")})
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want 401", i+1, w.Code)
+		}
+	}
+	w := app.do(t, testRequest{method: "POST", path: "/authenticate", body: authenticateBody("ann@example.com", "correct horse")})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked account: status %d, want 429", w.Code)
+	}
+
+	//unknown accounts are locked the same way
+	for i := 0; i < 5; i++ {
+		app.do(t, testRequest{method: "POST", path: "/authenticate", body: authenticateBody("nobody@example.com", "wrong")})
+	}
+	w = app.do(t, testRequest{method: "POST", path: "/authenticate", body: authenticateBody("nobody@example.com", "wrong")})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked unknown account: status %d, want 429", w.Code)
+	}
+}
+
+func TestAuthenticateOutageIsNotAFailure(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	outage := &outageDB{fakeDB: db, err: repository.ErrTimeout}
+	app.DB = outage
+
+	for i := 0; i < 10; i++ {
+		w := app.do(t, testRequest{method: "POST", path: "/authenticate", body: authenticateBody("ann@example.com", "correct horse")})
+		if w.Code == http.StatusUnauthorized || w.Code < 500 {
+			t.Fatalf("attempt %d during an outage: status %d, want 5xx", i+1, w.Code)
+		}
+	}
+
+	outage.err = nil
+	w := app.do(t, testRequest{method: "POST", path: "/authenticate", body: authenticateBody("ann@example.com", "correct horse")})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("after the outage: status %d, want 202: %s", w.Code, w.Body)
+	}
+}
+
+func TestAuthenticateBrokenHashIsNotAFailure(t *testing.T) {
+	app, db := newTestApp(t)
+	user := db.addUser("ann@example.com", "correct horse")
+	db.users[int(user.ID)].Password = "not a hash"
+
+	for i := 0; i < 6; i++ {
+		w := app.do(t, testRequest{method: "POST", path: "/authenticate", body: authenticateBody("ann@example.com", "correct horse")})
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("attempt %d: status %d, want 500", i+1, w.Code)
+		}
+	}
+}
+
+func TestChangePasswordLocksAfterFailures(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	token, _ := app.signIn(t, "ann@example.com", "correct horse")
+	change := func(current string) int {
+		t.Helper()
+		body := `{"current_password":"` + current + `","new_password":"battery staple"}`
+		return app.do(t, testRequest{method: "POST", path: "/me/password", body: body, token: token}).Code
+	}
+
+	//a right guess clears the earlier wrong ones
+	for i := 0; i < 4; i++ {
+		if status := change("wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want 401", i+1, status)
+		}
+	}
+	if status := change("correct horse"); status != http.StatusAccepted {
+		t.Fatalf("changing password: status %d, want 202", status)
+	}
+
+	for i := 0; i < 5; i++ {
+		if status := change("wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want 401", i+1, status)
+		}
+	}
+	if status := change("battery staple"); status != http.StatusTooManyRequests {
+		t.Fatalf("locked account: status %d, want 429", status)
+	}
+	//sign-in shares the lock
+	w := app.do(t, testRequest{method: "POST", path: "/authenticate", body: authenticateBody("ann@example.com", "battery staple")})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("signing in to the locked account: status %d, want 429", w.Code)
+	}
+}
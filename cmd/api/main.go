@@ -3,24 +3,63 @@ package main
 import (
 	"flag"
 	"fmt"
+	"go-restapi/inernal/mailer"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/oidc"
+	"go-restapi/inernal/password"
 	"go-restapi/inernal/repository"
 	"go-restapi/inernal/repository/dbrepo"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 const port = 8080
 
 type application struct {
-	DSN          string
-	Domain       string
-	DB           repository.DatabaseRepo
-	auth         Auth
-	JWTSecret    string
-	JWTIssuer    string
-	JWTAudience  string
-	CookieDomain string
+	DSN        string
+	DBTimeout  time.Duration
+	DBTimeouts string
+	Domain     string
+	DB         repository.DatabaseRepo
+	auth       Auth
+	JWTSecret  string
+	JWTKeyFile string
+	JWTOldKeys string
+	// JWTRetireSecret stops JWTSecret from verifying tokens once a signing
+	// key has replaced it.
+	JWTRetireSecret bool
+	JWTIssuer       string
+	JWTAudience     string
+	CookieDomain    string
+	LegacyErrors    bool
+	FrontendURL     string
+	APIURL          string
+	Mailer          mailer.Mailer
+	SMTP            mailer.SMTPMailer
+	MailLogFile     string
+	MFARoles        string
+	mfaRoles        map[string]bool
+	Lockout         LockoutConfig
+	loginLimits     loginLimits
+	OIDCConfig      string
+	OIDC            map[string]*oidc.Provider
+	OAuthClients    string
+	oauthClients    map[string]string
+	// TrustedProxies lists the reverse proxies whose X-Forwarded-For header
+	// names the client.
+	TrustedProxies string
+	trustedProxies []*net.IPNet
+	Password       PasswordConfig
+	// PasswordHashers stores new passwords and verifies stored ones.
+	PasswordHashers *password.Hashers
+	// PasswordPolicy is checked whenever a user chooses a new password.
+	PasswordPolicy password.Policy
 }
 
 func main() {
@@ -31,19 +70,80 @@ func main() {
 		"dsn",
 		"host=localhost port=5432 user=postgres password=postgres dbname=movies sslmode=disable timezone=UTC connect_timeout=5",
 		"Postgres connection string")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "itssecret", "signing secret")
+	flag.DurationVar(&app.DBTimeout, "db-timeout", 3*time.Second, "default timeout for a database operation")
+	flag.StringVar(&app.DBTimeouts, "db-timeouts", "", "per-operation database timeouts, e.g. SearchMovies=5s,AllMovies=5s")
+	flag.StringVar(&app.JWTSecret, "jwt-secret", "", "HS256 signing secret; required unless -jwt-signing-key is set, after which it only verifies tokens it signed earlier")
+	flag.StringVar(&app.JWTKeyFile, "jwt-signing-key", "", "PEM file with the RSA, P-256 or Ed25519 private key that signs tokens; HS256 with -jwt-secret is used when empty")
+	flag.StringVar(&app.JWTOldKeys, "jwt-verify-keys", "", "comma separated PEM files with retired keys that still verify tokens")
+	flag.BoolVar(&app.JWTRetireSecret, "jwt-retire-secret", false, "stop accepting tokens signed with -jwt-secret; set once -jwt-signing-key has been in use for longer than the refresh token lifetime")
 	flag.StringVar(&app.JWTIssuer, "jwt-issuer", "example.com", "signing issuer")
 	flag.StringVar(&app.JWTAudience, "jwt-audience", "example.com", "signing audience")
 	flag.StringVar(&app.CookieDomain, "cookie-domain", "localhost", "cookie domain")
 	flag.StringVar(&app.Domain, "domain", "example.com", "domain")
+	flag.BoolVar(&app.LegacyErrors, "legacy-errors", false, "send errors as the legacy {error, message} body instead of problem+json")
+	flag.StringVar(&app.FrontendURL, "frontend-url", "http://localhost:3000", "base URL of the web client, used in links sent by email")
+	flag.StringVar(&app.APIURL, "api-url", fmt.Sprintf("http://localhost:%d", port), "public base URL of this API, used in links sent by email")
+	flag.StringVar(&app.SMTP.Host, "smtp-host", "", "SMTP server host; mail is written to -mail-log when empty")
+	flag.IntVar(&app.SMTP.Port, "smtp-port", 587, "SMTP server port")
+	flag.StringVar(&app.SMTP.Username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&app.SMTP.Password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&app.SMTP.Sender, "smtp-sender", "Movies <no-reply@example.com>", "sender address for outgoing mail")
+	flag.StringVar(&app.MailLogFile, "mail-log", "", "file outgoing mail is appended to when no SMTP host is set; stdout when empty")
+	flag.StringVar(&app.MFARoles, "require-2fa-roles", "", "comma separated roles whose permissions are only granted once the user has enrolled in two-factor authentication, e.g. editor,admin")
+	flag.StringVar(&app.Lockout.Store, "lockout-store", "postgres", "where failed sign-in attempts are counted: postgres, shared by all instances, or memory")
+	flag.IntVar(&app.Lockout.AccountThreshold, "lockout-threshold", 5, "failed sign-ins for an account before it is locked")
+	flag.IntVar(&app.Lockout.IPThreshold, "lockout-ip-threshold", 20, "failed sign-ins from one IP before it is locked")
+	flag.DurationVar(&app.Lockout.BaseDelay, "lockout-delay", 30*time.Second, "first lockout period, doubled with every further failure")
+	flag.DurationVar(&app.Lockout.MaxDelay, "lockout-max-delay", time.Hour, "longest lockout period; failures older than this are forgotten")
+	flag.StringVar(&app.OIDCConfig, "oidc-config", "", "JSON file listing the OpenID Connect providers users can sign in with")
+	flag.StringVar(&app.OAuthClients, "oauth-clients", "", "comma separated client_id:client_secret pairs allowed to use /oauth/introspect and /oauth/revoke")
+	flag.StringVar(&app.Password.Hash, "password-hash", "argon2id", "algorithm new passwords are hashed with: argon2id or bcrypt; hashes made by the other keep working and are upgraded on sign-in; bcrypt limits passwords to 72 bytes")
+	flag.IntVar(&app.Password.MinLength, "password-min-length", models.MinPasswordLength, "shortest password users may choose")
+	flag.StringVar(&app.Password.BreachedFile, "breached-passwords", "", "file listing compromised passwords, one per line, that users may not choose")
+	flag.IntVar(&app.Password.History, "password-history", 5, "number of a user's most recent passwords that may not be chosen again; 0 allows reuse")
+	flag.StringVar(&app.TrustedProxies, "trusted-proxies", "", "comma separated addresses or CIDR ranges of reverse proxies or load balancers in front of the API; the client address of their requests is read from X-Forwarded-For or X-Real-IP")
 	flag.Parse()
+	if app.JWTKeyFile == "" && app.JWTSecret == "" {
+		log.Fatal("-jwt-secret is required when no -jwt-signing-key is set")
+	}
+	oauthClients, err := parseClients(app.OAuthClients)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.PasswordHashers, app.PasswordPolicy, err = app.Password.setup()
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.oauthClients = oauthClients
+	app.trustedProxies, err = parseProxies(app.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.mfaRoles = make(map[string]bool)
+	for _, role := range strings.Split(app.MFARoles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			app.mfaRoles[role] = true
+		}
+	}
 	//connect to db
 	conn, err := app.connectToDb()
 	if err != nil {
 		log.Fatal(err)
 	}
-	app.DB = &dbrepo.PostgresDBRepo{Db: conn}
+	app.OIDC, err = loadOIDCProviders(app.OIDCConfig, app.APIURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	timeouts, err := parseTimeouts(app.DBTimeouts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.DB = &dbrepo.PostgresDBRepo{Db: conn, Timeout: app.DBTimeout, Timeouts: timeouts}
 	defer app.DB.Connection().Close()
+	app.loginLimits, err = app.Lockout.limits(app.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
 	app.auth = Auth{
 		Issuer:        app.JWTIssuer,
 		Audience:      app.JWTAudience,
@@ -53,6 +153,39 @@ func main() {
 		CookiePath:    "/",
 		CookieDomain:  app.CookieDomain,
 		CookieName:    "__Host-refresh_token",
+		Denylist:      app.DB,
+	}
+	if app.JWTKeyFile != "" {
+		var oldKeys []string
+		for _, file := range strings.Split(app.JWTOldKeys, ",") {
+			if strings.TrimSpace(file) != "" {
+				oldKeys = append(oldKeys, strings.TrimSpace(file))
+			}
+		}
+		app.auth.Keys, err = NewKeySet(app.JWTKeyFile, oldKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if app.JWTRetireSecret {
+			app.auth.Secret = ""
+		}
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		go app.reloadKeys(sighup)
+	}
+	//set up outgoing mail
+	if app.SMTP.Host != "" {
+		app.Mailer = &app.SMTP
+	} else {
+		out := os.Stdout
+		if app.MailLogFile != "" {
+			out, err = os.OpenFile(app.MailLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer out.Close()
+		}
+		app.Mailer = &mailer.LogMailer{W: out, Sender: app.SMTP.Sender}
 	}
 	//start the application server
 	log.Println("starting application on port", port)
@@ -61,3 +194,16 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// reloadKeys re-reads the JWT key files on every signal, SIGHUP in main, so
+// keys can be rotated without restarting the server.
+func (app *application) reloadKeys(signals <-chan os.Signal) {
+	for range signals {
+		err := app.auth.Keys.Reload()
+		if err != nil {
+			log.Println("reloading signing keys:", err)
+			continue
+		}
+		log.Println("reloaded signing keys")
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "net/http"
+import (
+	"go-restapi/inernal/apperror"
+	"net/http"
+)
 
 func (app *application) enableCors(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -9,7 +12,7 @@ func (app *application) enableCors(h http.Handler) http.Handler {
 		if r.Method == "OPTIONS" {
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Authorization, X-API-Key")
 			return
 		} else {
 			h.ServeHTTP(w, r)
@@ -17,11 +20,57 @@ func (app *application) enableCors(h http.Handler) http.Handler {
 	})
 }
 
+// authRequired lets through callers with a valid bearer access token or an
+// X-API-Key header, and stores who they are in the request context.
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			principal, err := app.authenticateAPIKey(r.Context(), key)
+			if err != nil {
+				app.errorJSON(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(contextWithPrincipal(r.Context(), principal)))
+			return
+		}
+
+		_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+		if err != nil {
+			//failing to check the denylist is not the caller's fault
+			if isRepositoryError(err) {
+				app.errorJSON(w, r, err)
+				return
+			}
+			app.errorJSON(w, r, apperror.Unauthorized(err.Error()))
+			return
+		}
+		principal, err := principalFromClaims(claims)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			app.errorJSON(w, r, apperror.Unauthorized(err.Error()))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(contextWithPrincipal(r.Context(), principal)))
+	})
+}
+
+// userRequired refuses principals that are not a signed-in user, such as API
+// keys, on account endpoints. It must run after authRequired.
+func (app *application) userRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.principal(r).APIKeyID != 0 {
+			app.errorJSON(w, r, apperror.Forbidden("this endpoint is not available to API keys"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refuseImpersonation keeps impersonation tokens away from sensitive
+// endpoints such as password changes. It must run after authRequired.
+func (app *application) refuseImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.principal(r).Impersonated() {
+			app.errorJSON(w, r, apperror.Forbidden("this endpoint is not available while impersonating a user").WithCode("impersonation_refused"))
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tokenHintAccess  = "access_token"
+	tokenHintRefresh = "refresh_token"
+	// maxFormSize limits the form bodies of the oauth endpoints.
+	maxFormSize = 64 << 10
+)
+
+// parseClients reads the oauth clients allowed to introspect and revoke
+// tokens, given as client_id:client_secret,...
+func parseClients(list string) (map[string]string, error) {
+	clients := make(map[string]string)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, secret, found := strings.Cut(entry, ":")
+		if !found || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid oauth client %q", id)
+		}
+		clients[id] = secret
+	}
+	return clients, nil
+}
+
+// clientRequired lets through oauth clients authenticating with HTTP Basic
+// client credentials, and API keys granted tokens:manage.
+func (app *application) clientRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			principal, err := app.authenticateAPIKey(r.Context(), key)
+			if err != nil {
+				app.errorJSON(w, r, err)
+				return
+			}
+			if !principal.HasScope(models.PermissionTokensManage) {
+				app.errorJSON(w, r, apperror.Forbidden("missing permission "+models.PermissionTokensManage))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, secret, ok := r.BasicAuth()
+		expected, known := app.oauthClients[id]
+		//compare against something even for unknown clients to keep timing flat
+		if !known {
+			expected = secret + "-"
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) != 1 || !known {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			app.errorJSON(w, r, apperror.Unauthorized("invalid client credentials").WithCode("invalid_client"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readTokenForm reads the token and token_type_hint form fields shared by
+// introspection and revocation.
+func (app *application) readTokenForm(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	err := r.ParseForm()
+	if err != nil {
+		return "", "", apperror.BadRequest("request body must be form encoded")
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		return "", "", apperror.Validation("token is required").WithCode("invalid_request")
+	}
+	return token, r.PostForm.Get("token_type_hint"), nil
+}
+
+// Introspection is an RFC 7662 introspection response. Only Active is set
+// for tokens that are not active.
+type Introspection struct {
+	Active    bool     `json:"active"`
+	TokenType string   `json:"token_type,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	TokenID   string   `json:"jti,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	Actor     *Actor   `json:"act,omitempty"`
+}
+
+func newIntrospection(claims *Claims, tokenType string) Introspection {
+	in := Introspection{
+		Active:    true,
+		TokenType: tokenType,
+		Scope:     claims.Scope,
+		Username:  claims.Name,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		TokenID:   claims.ID,
+		Roles:     claims.Roles,
+		SessionID: claims.SessionID,
+		Actor:     claims.Actor,
+	}
+	if claims.ExpiresAt != nil {
+		in.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		in.IssuedAt = claims.IssuedAt.Unix()
+	}
+	return in
+}
+
+// Introspect tells other services whether a token we issued is active, as
+// in RFC 7662.
+func (app *application) Introspect(w http.ResponseWriter, r *http.Request) {
+	token, hint, err := app.readTokenForm(w, r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	//the hint only decides which kind of token is tried first
+	kinds := []string{tokenHintAccess, tokenHintRefresh}
+	if hint == tokenHintRefresh {
+		kinds = []string{tokenHintRefresh, tokenHintAccess}
+	}
+	for _, kind := range kinds {
+		var claims *Claims
+		if kind == tokenHintAccess {
+			claims, err = app.auth.VerifyAccessToken(r.Context(), token)
+		} else {
+			claims, err = app.activeRefreshToken(r, token)
+		}
+		if err != nil {
+			if isRepositoryError(err) && !apperror.Is(err, apperror.KindNotFound) {
+				app.errorJSON(w, r, err)
+				return
+			}
+			continue
+		}
+		_ = app.writeJSON(w, http.StatusOK, newIntrospection(claims, kind))
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, Introspection{Active: false})
+}
+
+// activeRefreshToken verifies a refresh token and checks its server-side
+// record is still usable.
+func (app *application) activeRefreshToken(r *http.Request, token string) (*Claims, error) {
+	claims, err := app.auth.VerifyToken(token, tokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := app.DB.GetRefreshToken(r.Context(), claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if stored.UsedAt != nil || stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) ||
+		claims.Subject != strconv.Itoa(stored.UserID) {
+		return nil, errTokenRevoked
+	}
+	claims.SessionID = stored.FamilyID
+	return claims, nil
+}
+
+// Revoke revokes an access or refresh token, as in RFC 7009. Access tokens go
+// on the denylist until they expire; revoking a refresh token ends its whole
+// session. Unknown and invalid tokens are not an error.
+func (app *application) Revoke(w http.ResponseWriter, r *http.Request) {
+	token, hint, err := app.readTokenForm(w, r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	kinds := []string{tokenHintAccess, tokenHintRefresh}
+	if hint == tokenHintRefresh {
+		kinds = []string{tokenHintRefresh, tokenHintAccess}
+	}
+	for _, kind := range kinds {
+		if kind == tokenHintAccess {
+			claims, err := app.auth.VerifyToken(token, tokenTypeAccess)
+			if err != nil || claims.ID == "" {
+				continue
+			}
+			err = app.DB.RevokeAccessToken(r.Context(), claims.ID, claims.ExpiresAt.Time)
+			if err != nil {
+				app.errorJSON(w, r, err)
+				return
+			}
+			break
+		}
+
+		claims, err := app.auth.VerifyToken(token, tokenTypeRefresh)
+		if err != nil {
+			continue
+		}
+		stored, err := app.DB.GetRefreshToken(r.Context(), claims.ID)
+		if err != nil {
+			if apperror.Is(err, apperror.KindNotFound) {
+				break
+			}
+			app.errorJSON(w, r, err)
+			return
+		}
+		err = app.DB.RevokeTokenFamily(r.Context(), stored.FamilyID)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+		break
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"go-restapi/inernal/models"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// oauthRequest posts token to an oauth endpoint as the client id with secret.
+func oauthRequest(path, id, secret, token, hint string) testRequest {
+	form := url.Values{"token": {token}}
+	if hint != "" {
+		form.Set("token_type_hint", hint)
+	}
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	if id != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(id+":"+secret)))
+	}
+	return testRequest{method: "POST", path: path, body: form.Encode(), header: header}
+}
+
+// newOAuthTestApp returns a test application with one oauth client, "rs",
+// whose secret is "rs-secret".
+func newOAuthTestApp(t *testing.T) (*application, *fakeDB) {
+	t.Helper()
+	app, db := newTestApp(t)
+	app.oauthClients = map[string]string{"rs": "rs-secret"}
+	return app, db
+}
+
+func introspect(t *testing.T, app *application, token, hint string) Introspection {
+	t.Helper()
+	w := app.do(t, oauthRequest("/oauth/introspect", "rs", "rs-secret", token, hint))
+	if w.Code != http.StatusOK {
+		t.Fatalf("introspecting: status %d: %s", w.Code, w.Body)
+	}
+	var in Introspection
+	decode(t, w, &in)
+	return in
+}
+
+func TestParseClients(t *testing.T) {
+	clients, err := parseClients(" rs:one, gateway:two:three ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || clients["rs"] != "one" || clients["gateway"] != "two:three" {
+		t.Errorf("clients = %v", clients)
+	}
+	for _, list := range []string{"rs", "rs:", ":secret"} {
+		if _, err := parseClients(list); err == nil {
+			t.Errorf("%q accepted", list)
+		}
+	}
+}
+
+func TestOAuthClientGate(t *testing.T) {
+	app, db := newOAuthTestApp(t)
+	user := db.addUser("ann@example.com", "correct horse", models.PermissionTokensManage, models.PermissionMoviesWrite)
+	expired := time.Now().Add(-time.Minute)
+	manageKey := db.addAPIKey(int(user.ID), nil, models.PermissionTokensManage)
+	otherKey := db.addAPIKey(int(user.ID), nil, models.PermissionMoviesWrite)
+	expiredKey := db.addAPIKey(int(user.ID), &expired, models.PermissionTokensManage)
+
+	for _, path := range []string{"/oauth/introspect", "/oauth/revoke"} {
+		tests := []struct {
+			name   string
+			id     string
+			secret string
+			apiKey string
+			status int
+		}{
+			{"no credentials", "", "", "", http.StatusUnauthorized},
+			{"wrong secret", "rs", "nope", "", http.StatusUnauthorized},
+			{"unknown client", "someone", "rs-secret", "", http.StatusUnauthorized},
+			{"empty secret", "rs", "", "", http.StatusUnauthorized},
+			{"client", "rs", "rs-secret", "", http.StatusOK},
+			{"api key", "", "", manageKey, http.StatusOK},
+			{"api key without tokens:manage", "", "", otherKey, http.StatusForbidden},
+			{"expired api key", "", "", expiredKey, http.StatusUnauthorized},
+			{"unknown api key", "", "", models.APIKeyPrefix + "nope", http.StatusUnauthorized},
+		}
+		for _, tt := range tests {
+			req := oauthRequest(path, tt.id, tt.secret, "not-a-token", "")
+			if tt.apiKey != "" {
+				req.header.Set("X-API-Key", tt.apiKey)
+			}
+			w := app.do(t, req)
+			if w.Code != tt.status {
+				t.Errorf("%s %s: status %d, want %d", path, tt.name, w.Code, tt.status)
+			}
+			if w.Code == http.StatusUnauthorized && tt.apiKey == "" && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%s %s: no WWW-Authenticate challenge", path, tt.name)
+			}
+		}
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	app, db := newOAuthTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	token, cookie := app.signIn(t, "ann@example.com", "correct horse")
+
+	in := introspect(t, app, token, "")
+	if !in.Active || in.TokenType != tokenHintAccess || in.Subject != "1" || in.SessionID != sessionOf(t, app, token) {
+		t.Errorf("access token: %+v", in)
+	}
+	in = introspect(t, app, cookie.Value, "")
+	if !in.Active || in.TokenType != tokenHintRefresh || in.Subject != "1" {
+		t.Errorf("refresh token: %+v", in)
+	}
+	in = introspect(t, app, cookie.Value, tokenHintAccess)
+	if !in.Active || in.TokenType != tokenHintRefresh {
+		t.Errorf("refresh token with the wrong hint: %+v", in)
+	}
+	if in := introspect(t, app, "not-a-token", ""); !reflect.DeepEqual(in, Introspection{}) {
+		t.Errorf("garbage: %+v", in)
+	}
+
+	w := app.do(t, oauthRequest("/oauth/introspect", "rs", "rs-secret", "", ""))
+	if w.Code == http.StatusOK {
+		t.Error("request without a token accepted")
+	}
+
+	//a used refresh token is no longer active
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: cookie})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refreshing: status %d", w.Code)
+	}
+	if in := introspect(t, app, cookie.Value, tokenHintRefresh); in.Active {
+		t.Errorf("used refresh token: %+v", in)
+	}
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	app, db := newOAuthTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	token, cookie := app.signIn(t, "ann@example.com", "correct horse")
+	other, _ := app.signIn(t, "ann@example.com", "correct horse")
+
+	w := app.do(t, oauthRequest("/oauth/revoke", "rs", "rs-secret", token, tokenHintAccess))
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoking: status %d: %s", w.Code, w.Body)
+	}
+	if in := introspect(t, app, token, ""); !reflect.DeepEqual(in, Introspection{}) {
+		t.Errorf("revoked access token: %+v", in)
+	}
+	w = app.do(t, testRequest{method: "GET", path: "/me/", token: token})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked access token on /me/: status %d", w.Code)
+	}
+
+	//only that token is revoked, not its session or other sessions
+	if !db.activeFamily(sessionOf(t, app, token)) {
+		t.Error("revoking an access token revoked its session")
+	}
+	if in := introspect(t, app, other, ""); !in.Active {
+		t.Error("another session's access token was revoked")
+	}
+	if in := introspect(t, app, cookie.Value, ""); !in.Active {
+		t.Error("the session's refresh token was revoked")
+	}
+
+	//unknown and invalid tokens are not an error
+	for _, token := range []string{"not-a-token", token} {
+		w := app.do(t, oauthRequest("/oauth/revoke", "rs", "rs-secret", token, ""))
+		if w.Code != http.StatusOK {
+			t.Errorf("revoking %q: status %d", token, w.Code)
+		}
+	}
+}
+
+func TestRevokeRefreshTokenRevokesFamily(t *testing.T) {
+	app, db := newOAuthTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	token, cookie := app.signIn(t, "ann@example.com", "correct horse")
+	other, otherCookie := app.signIn(t, "ann@example.com", "correct horse")
+	session := sessionOf(t, app, token)
+
+	w := app.do(t, oauthRequest("/oauth/revoke", "rs", "rs-secret", cookie.Value, tokenHintRefresh))
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoking: status %d: %s", w.Code, w.Body)
+	}
+	if db.activeFamily(session) {
+		t.Error("token family still active")
+	}
+	if in := introspect(t, app, cookie.Value, tokenHintRefresh); in.Active {
+		t.Errorf("revoked refresh token: %+v", in)
+	}
+	//the session's access tokens go with it
+	if in := introspect(t, app, token, ""); in.Active {
+		t.Errorf("access token of the revoked session: %+v", in)
+	}
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: cookie})
+	if w.Code == http.StatusOK {
+		t.Error("revoked refresh token still refreshes")
+	}
+
+	if !db.activeFamily(sessionOf(t, app, other)) {
+		t.Error("another session was revoked")
+	}
+	if in := introspect(t, app, otherCookie.Value, ""); !in.Active {
+		t.Error("another session's refresh token was revoked")
+	}
+}
+
+func TestVerifyAccessTokenRefusesDenylistedToken(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	token, _ := app.signIn(t, "ann@example.com", "correct horse")
+	claims, err := app.auth.VerifyAccessToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.RevokeAccessToken(context.Background(), claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.auth.VerifyAccessToken(context.Background(), token)
+	if !errors.Is(err, errTokenRevoked) {
+		t.Errorf("denylisted token: err = %v, want errTokenRevoked", err)
+	}
+	//the signature is still fine, only the denylist refuses it
+	if _, err := app.auth.VerifyToken(token, tokenTypeAccess); err != nil {
+		t.Errorf("VerifyToken: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/oidc"
+	"go-restapi/inernal/repository"
+	"go-restapi/inernal/validator"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	oidcStateTTL    = 10 * time.Minute
+	oidcStateCookie = "oidc_state"
+)
+
+var errOIDCLoginFailed = apperror.Unauthorized("single sign-on failed").WithCode("oidc_login_failed")
+
+// loadOIDCProviders reads the identity providers from the JSON config file,
+// shaped as {"providers": [{"name": ..., "issuer": ..., "client_id": ...}]}.
+func loadOIDCProviders(file, apiURL string) (map[string]*oidc.Provider, error) {
+	providers := make(map[string]*oidc.Provider)
+	if file == "" {
+		return providers, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Providers []*oidc.Provider `json:"providers"`
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for _, p := range config.Providers {
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("%s: every provider needs a name, issuer and client_id", file)
+		}
+		if providers[p.Name] != nil {
+			return nil, fmt.Errorf("%s: provider %q is configured twice", file, p.Name)
+		}
+		if p.RedirectURL == "" {
+			p.RedirectURL = strings.TrimSuffix(apiURL, "/") + "/auth/oidc/" + p.Name + "/callback"
+		}
+		providers[p.Name] = p
+	}
+	return providers, nil
+}
+
+func (app *application) oidcProvider(r *http.Request) (*oidc.Provider, error) {
+	provider, ok := app.OIDC[chi.URLParam(r, "provider")]
+	if !ok {
+		return nil, apperror.NotFound("unknown identity provider")
+	}
+	return provider, nil
+}
+
+// OIDCStart sends the user to the identity provider, remembering state,
+// nonce and PKCE verifier in a short-lived signed cookie.
+func (app *application) OIDCStart(w http.ResponseWriter, r *http.Request) {
+	provider, err := app.oidcProvider(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	login := oidcLogin{Provider: provider.Name}
+	for _, value := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		*value, err = oidc.GenerateVerifier()
+		if err != nil {
+			app.errorJSON(w, r, apperror.Internal(err))
+			return
+		}
+	}
+	redirectURL, err := provider.AuthCodeURL(r.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	stateToken, err := app.auth.GenerateOIDCStateToken(login, oidcStateTTL)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+
+	http.SetCookie(w, app.oidcStateCookie(provider.Name, stateToken, oidcStateTTL))
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// OIDCCallback finishes the sign-in when the provider sends the user back,
+// and answers like authenticate does.
+func (app *application) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, err := app.oidcProvider(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	//the state cookie is single use
+	http.SetCookie(w, app.oidcStateCookie(provider.Name, "", -1))
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		app.errorJSON(w, r, apperror.Unauthorized("identity provider refused the sign-in: "+query.Get("error")).WithCode("oidc_login_failed"))
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		app.errorJSON(w, r, errOIDCLoginFailed)
+		return
+	}
+	claims, err := app.auth.VerifyToken(cookie.Value, tokenTypeOIDCState)
+	if err != nil || claims.OIDC == nil || claims.OIDC.Provider != provider.Name ||
+		query.Get("state") == "" || claims.OIDC.State != query.Get("state") {
+		app.errorJSON(w, r, errOIDCLoginFailed)
+		return
+	}
+
+	idToken, err := provider.Exchange(r.Context(), query.Get("code"), claims.OIDC.Verifier, claims.OIDC.Nonce)
+	if err != nil {
+		//a refused code or a bad token is the client's problem; anything else
+		//means we could not talk to the provider
+		if errors.Is(err, oidc.ErrInvalidIDToken) || errors.Is(err, oidc.ErrCodeRejected) {
+			app.errorJSON(w, r, errOIDCLoginFailed)
+			return
+		}
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+
+	user, err := app.DB.GetUserByIdentity(r.Context(), provider.Name, idToken.Subject)
+	if errors.Is(err, repository.ErrIdentityNotFound) {
+		user, err = app.linkOIDCUser(r.Context(), provider, idToken)
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !user.Verified() {
+		app.errorJSON(w, r, errEmailNotVerified)
+		return
+	}
+	if user.TOTPEnabled {
+		app.mfaChallenge(w, r, user)
+		return
+	}
+
+	app.issueSession(w, r, user)
+}
+
+// linkOIDCUser attaches a new external identity to the account with the same
+// verified email when the provider is trusted to link by email, or creates
+// an account when the provider allows it.
+func (app *application) linkOIDCUser(ctx context.Context, provider *oidc.Provider, idToken *oidc.IDToken) (*models.User, error) {
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return nil, apperror.Forbidden("identity provider did not share a verified email address").WithCode("oidc_email_unverified")
+	}
+	email := models.NormalizeEmail(idToken.Email)
+	identity := models.Identity{
+		Provider: provider.Name,
+		Subject:  idToken.Subject,
+		Email:    email,
+	}
+
+	user, err := app.DB.GetUserByEmail(ctx, email)
+	if err == nil && !provider.LinkByEmail {
+		return nil, apperror.Forbidden("an account with this email already exists; sign in with its password instead").WithCode("oidc_account_exists")
+	}
+	if err == nil {
+		identity.UserID = int(user.ID)
+		err = app.DB.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+			err := repo.LinkIdentity(ctx, identity)
+			if err != nil {
+				return err
+			}
+			//the provider vouched for the address
+			if !user.Verified() {
+				return repo.MarkUserVerified(ctx, int(user.ID), email)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return app.DB.GetUserById(ctx, int(user.ID))
+	}
+	if !apperror.Is(err, apperror.KindNotFound) {
+		return nil, err
+	}
+	if !provider.AutoProvision {
+		return nil, apperror.Forbidden("no account exists for this identity").WithCode("oidc_account_not_found")
+	}
+
+	now := time.Now()
+	user = &models.User{
+		FirstName:  idToken.GivenName,
+		LastName:   idToken.FamilyName,
+		Email:      email,
+		CreatedAt:  now,
+		UpdateAt:   now,
+		VerifiedAt: &now,
+		Roles:      []string{defaultRole},
+	}
+	if user.FirstName == "" || user.LastName == "" {
+		user.FirstName, user.LastName = splitName(idToken.Name, email)
+	}
+	v := validator.New()
+	user.Check(v)
+	err = v.Err()
+	if err != nil {
+		return nil, err
+	}
+	//provisioned accounts sign in through the provider; a password can be set
+	//later with the reset flow
+	password, _, err := models.GenerateToken()
+	if err != nil {
+		return nil, apperror.Internal(err)
+	}
+	err = user.SetPassword(app.PasswordHashers, password)
+	if err != nil {
+		return nil, apperror.Internal(err)
+	}
+
+	err = app.DB.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		newID, err := repo.InsertUser(ctx, *user)
+		if err != nil {
+			return err
+		}
+		user.ID = int64(newID)
+		identity.UserID = newID
+		err = repo.SetUserRoles(ctx, newID, user.Roles)
+		if err != nil {
+			return err
+		}
+		return repo.LinkIdentity(ctx, identity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// splitName turns a display name into first and last name, falling back to
+// the local part of email.
+func splitName(name, email string) (string, string) {
+	fields := strings.Fields(name)
+	switch len(fields) {
+	case 0:
+		local, _, _ := strings.Cut(email, "@")
+		return local, local
+	case 1:
+		return fields[0], fields[0]
+	}
+	return strings.Join(fields[:len(fields)-1], " "), fields[len(fields)-1]
+}
+
+func (app *application) oidcStateCookie(provider, value string, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:  oidcStateCookie,
+		Path:  "/auth/oidc/" + provider + "/",
+		Value: value,
+		//lax, so the cookie comes back on the provider's top-level redirect
+		SameSite: http.SameSiteLaxMode,
+		Domain:   app.CookieDomain,
+		HttpOnly: true,
+		Secure:   true,
+	}
+	if maxAge < 0 {
+		cookie.Expires = time.Unix(0, 0)
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = time.Now().Add(maxAge)
+		cookie.MaxAge = int(maxAge.Seconds())
+	}
+	return cookie
+}
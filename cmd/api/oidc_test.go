@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/oidc"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientID = "movies-api"
+
+// fakeIssuer is an OpenID Connect provider serving discovery, JWKS and a
+// token endpoint that checks the PKCE verifier and hands out each code once.
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	challenge string
+	claims    oidc.IDToken
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &fakeIssuer{key: key, grants: make(map[string]fakeGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidc.Discovery{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JWKSURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (issuer *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	issuer.mu.Lock()
+	grant, ok := issuer.grants[r.PostForm.Get("code")]
+	delete(issuer.grants, r.PostForm.Get("code"))
+	issuer.mu.Unlock()
+	if !ok || oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+	token.Header["kid"] = "test-key"
+	idToken, err := token.SignedString(issuer.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+}
+
+// authorize plays the user signing in at the provider after being sent to
+// location, and returns the authorization code. The ID token carries the
+// nonce from location unless claims name another one.
+func (issuer *fakeIssuer) authorize(t *testing.T, location string, claims oidc.IDToken) string {
+	t.Helper()
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if u.Path != "/authorize" || query.Get("client_id") != testClientID || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", location)
+	}
+	if claims.Nonce == "" {
+		claims.Nonce = query.Get("nonce")
+	}
+	claims.Issuer = issuer.URL
+	claims.Audience = jwt.ClaimStrings{testClientID}
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(5 * time.Minute))
+
+	code, err := oidc.GenerateVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.mu.Lock()
+	issuer.grants[code] = fakeGrant{challenge: query.Get("code_challenge"), claims: claims}
+	issuer.mu.Unlock()
+	return code
+}
+
+// newOIDCTestApp returns a test application that trusts issuer as the
+// provider named "test".
+func newOIDCTestApp(t *testing.T, issuer *fakeIssuer) (*application, *fakeDB) {
+	t.Helper()
+	app, db := newTestApp(t)
+	app.OIDC = map[string]*oidc.Provider{
+		"test": {
+			Name:        "test",
+			Issuer:      issuer.URL,
+			ClientID:    testClientID,
+			RedirectURL: app.APIURL + "/auth/oidc/test/callback",
+			HTTPClient:  issuer.Client(),
+		},
+	}
+	return app, db
+}
+
+// startOIDC begins a sign-in and returns where the user is sent, the state
+// and the state cookie.
+func startOIDC(t *testing.T, app *application) (string, string, *http.Cookie) {
+	t.Helper()
+	w := app.do(t, testRequest{method: "GET", path: "/auth/oidc/test/start"})
+	if w.Code != http.StatusFound {
+		t.Fatalf("starting sign-in: status %d: %s", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return location, u.Query().Get("state"), &http.Cookie{Name: cookie.Name, Value: cookie.Value}
+		}
+	}
+	t.Fatal("starting sign-in set no state cookie")
+	return "", "", nil
+}
+
+func callbackOIDC(t *testing.T, app *application, code, state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	query := url.Values{"code": {code}, "state": {state}}
+	return app.do(t, testRequest{
+		method: "GET",
+		path:   "/auth/oidc/test/callback?" + query.Encode(),
+		cookie: cookie,
+	})
+}
+
+func identityClaims(subject, email string, verified bool) oidc.IDToken {
+	return oidc.IDToken{
+		Email:            email,
+		EmailVerified:    verified,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: subject},
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	app, db := newOIDCTestApp(t, issuer)
+	user := db.addUser("ann@example.com", "correct horse")
+	err := db.LinkIdentity(context.Background(), models.Identity{Provider: "test", Subject: "ann-subject", UserID: int(user.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, state, cookie := startOIDC(t, app)
+	//the provider's email is not used once the identity is linked
+	code := issuer.authorize(t, location, identityClaims("ann-subject", "", false))
+	w := callbackOIDC(t, app, code, state, cookie)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	decode(t, w, &tokens)
+	claims, err := app.auth.VerifyAccessToken(context.Background(), tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "1" {
+		t.Errorf("signed in as user %s, want 1", claims.Subject)
+	}
+	refreshCookie(t, app, w)
+
+	//the code was spent
+	w = callbackOIDC(t, app, code, state, cookie)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code: status %d, want 401", w.Code)
+	}
+}
+
+func TestOIDCDoesNotLinkByEmailByDefault(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	app, db := newOIDCTestApp(t, issuer)
+	user := db.addUser("ann@example.com", "correct horse")
+	db.users[int(user.ID)].VerifiedAt = nil
+
+	location, state, cookie := startOIDC(t, app)
+	code := issuer.authorize(t, location, identityClaims("mallory-subject", "ann@example.com", true))
+	w := callbackOIDC(t, app, code, state, cookie)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403: %s", w.Code, w.Body)
+	}
+	if len(db.identities) != 0 {
+		t.Error("identity linked without link_by_email")
+	}
+	if len(db.sessions) != 0 {
+		t.Error("session started for the existing account")
+	}
+	if db.users[int(user.ID)].Verified() {
+		t.Error("account marked verified by a provider that may not link it")
+	}
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	app, db := newOIDCTestApp(t, issuer)
+	app.OIDC["test"].LinkByEmail = true
+	user := db.addUser("ann@example.com", "correct horse")
+	db.users[int(user.ID)].VerifiedAt = nil
+
+	//an unverified address at the provider links nothing
+	location, state, cookie := startOIDC(t, app)
+	code := issuer.authorize(t, location, identityClaims("ann-subject", "ann@example.com", false))
+	w := callbackOIDC(t, app, code, state, cookie)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("unverified email: status %d, want 403: %s", w.Code, w.Body)
+	}
+	if len(db.identities) != 0 {
+		t.Fatal("identity linked through an unverified email")
+	}
+
+	location, state, cookie = startOIDC(t, app)
+	code = issuer.authorize(t, location, identityClaims("ann-subject", "Ann@Example.com", true))
+	w = callbackOIDC(t, app, code, state, cookie)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	identity := db.identities[[2]string{"test", "ann-subject"}]
+	if identity == nil || identity.UserID != int(user.ID) || identity.Email != "ann@example.com" {
+		t.Fatalf("identity not linked to the account: %+v", identity)
+	}
+	if !db.users[int(user.ID)].Verified() {
+		t.Error("account not marked verified by the provider")
+	}
+}
+
+func TestOIDCRejectsBadCallbacks(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	app, db := newOIDCTestApp(t, issuer)
+	user := db.addUser("ann@example.com", "correct horse")
+	err := db.LinkIdentity(context.Background(), models.Identity{Provider: "test", Subject: "ann-subject", UserID: int(user.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := identityClaims("ann-subject", "ann@example.com", true)
+
+	t.Run("state mismatch", func(t *testing.T) {
+		location, _, cookie := startOIDC(t, app)
+		code := issuer.authorize(t, location, claims)
+		w := callbackOIDC(t, app, code, "forged-state", cookie)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want 401", w.Code)
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		location, state, cookie := startOIDC(t, app)
+		forged := claims
+		forged.Nonce = "forged-nonce"
+		code := issuer.authorize(t, location, forged)
+		w := callbackOIDC(t, app, code, state, cookie)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want 401", w.Code)
+		}
+	})
+
+	t.Run("bad PKCE verifier", func(t *testing.T) {
+		//a code issued for someone else's sign-in, injected into ours
+		victim, _, _ := startOIDC(t, app)
+		code := issuer.authorize(t, victim, claims)
+		_, state, cookie := startOIDC(t, app)
+		w := callbackOIDC(t, app, code, state, cookie)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want 401: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("provider unreachable", func(t *testing.T) {
+		location, state, cookie := startOIDC(t, app)
+		code := issuer.authorize(t, location, claims)
+		issuer.Close()
+		w := callbackOIDC(t, app, code, state, cookie)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", w.Code)
+		}
+	})
+
+	if len(db.sessions) != 0 {
+		t.Errorf("%d sessions started by rejected callbacks", len(db.sessions))
+	}
+}
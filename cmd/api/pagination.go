@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/repository"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type PageMetadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+type PageLinks struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+type MoviePage struct {
+	Movies   []*models.Movie `json:"movies"`
+	Metadata PageMetadata    `json:"metadata"`
+	Links    PageLinks       `json:"links"`
+}
+
+// readMovieQuery parses the paging, sorting and filtering query parameters
+// of a movie listing request.
+func readMovieQuery(r *http.Request) (repository.MovieQuery, error) {
+	var err error
+	values := r.URL.Query()
+	query := repository.MovieQuery{
+		Page:       1,
+		PageSize:   defaultPageSize,
+		MPAARating: values.Get("mpaa_rating"),
+	}
+
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"page", &query.Page},
+		{"page_size", &query.PageSize},
+		{"genre_id", &query.GenreID},
+		{"year_from", &query.YearFrom},
+		{"year_to", &query.YearTo},
+		{"runtime_min", &query.RuntimeMin},
+		{"runtime_max", &query.RuntimeMax},
+	}
+	for _, p := range ints {
+		if values.Get(p.name) == "" {
+			continue
+		}
+		*p.dest, err = strconv.Atoi(values.Get(p.name))
+		if err != nil || *p.dest < 0 {
+			return query, apperror.BadRequest(fmt.Sprintf("%s must be a non-negative integer", p.name))
+		}
+	}
+	if query.Page < 1 {
+		return query, apperror.BadRequest("page must be a positive integer")
+	}
+	if query.PageSize < 1 || query.PageSize > maxPageSize {
+		return query, apperror.BadRequest(fmt.Sprintf("page_size must be between 1 and %d", maxPageSize))
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		valid := false
+		for _, f := range repository.MovieSortFields {
+			if sort == f {
+				valid = true
+			}
+		}
+		if !valid {
+			return query, apperror.BadRequest(fmt.Sprintf("cannot sort by %q", sort))
+		}
+		query.Sort = sort
+	}
+	switch values.Get("direction") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, apperror.BadRequest("direction must be asc or desc")
+	}
+
+	return query, nil
+}
+
+func newMoviePage(r *http.Request, query repository.MovieQuery, movies []*models.Movie, total int) MoviePage {
+	lastPage := (total + query.PageSize - 1) / query.PageSize
+	if movies == nil {
+		movies = []*models.Movie{}
+	}
+	page := MoviePage{
+		Movies: movies,
+		Metadata: PageMetadata{
+			CurrentPage:  query.Page,
+			PageSize:     query.PageSize,
+			LastPage:     lastPage,
+			TotalRecords: total,
+		},
+	}
+	if query.Page < lastPage {
+		page.Links.Next = pageLink(r, query.Page+1)
+	}
+	if query.Page > 1 && lastPage > 0 {
+		prev := query.Page - 1
+		if prev > lastPage {
+			prev = lastPage
+		}
+		page.Links.Prev = pageLink(r, prev)
+	}
+	return page
+}
+
+// pageLink returns the request URL with its page parameter replaced.
+func pageLink(r *http.Request, page int) string {
+	u := *r.URL
+	values := u.Query()
+	values.Set("page", strconv.Itoa(page))
+	u.RawQuery = values.Encode()
+	return u.RequestURI()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadMovieQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"defaults", "", false},
+		{"first page", "page=1&page_size=1", false},
+		{"largest page size", "page_size=100", false},
+		{"page zero", "page=0", true},
+		{"negative page", "page=-1", true},
+		{"page not a number", "page=two", true},
+		{"page size zero", "page_size=0", true},
+		{"page size too large", "page_size=101", true},
+		{"whitelisted sort", "sort=runtime&direction=desc", false},
+		{"unknown sort", "sort=description", true},
+		{"unknown direction", "direction=sideways", true},
+		{"zero filter", "runtime_min=0", false},
+		{"negative filter", "runtime_min=-5", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/movies?"+tt.query, nil)
+			_, err := readMovieQuery(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readMovieQuery(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadMovieQueryValues(t *testing.T) {
+	r := httptest.NewRequest("GET", "/movies?page=2&page_size=10&sort=release_date&direction=desc&genre_id=7&mpaa_rating=PG", nil)
+	q, err := readMovieQuery(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Page != 2 || q.PageSize != 10 || q.Sort != "release_date" || !q.Desc || q.GenreID != 7 || q.MPAARating != "PG" {
+		t.Errorf("unexpected query %+v", q)
+	}
+	if q.Offset() != 10 {
+		t.Errorf("Offset() = %d, want 10", q.Offset())
+	}
+
+	r = httptest.NewRequest("GET", "/movies", nil)
+	q, err = readMovieQuery(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Page != 1 || q.PageSize != defaultPageSize || q.Sort != "" || q.Desc {
+		t.Errorf("unexpected defaults %+v", q)
+	}
+}
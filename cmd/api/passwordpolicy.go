@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/password"
+	"go-restapi/inernal/repository"
+	"go-restapi/inernal/validator"
+	"log"
+)
+
+type PasswordConfig struct {
+	Hash         string
+	MinLength    int
+	BreachedFile string
+	History      int
+}
+
+const bcryptCost = 12
+
+// setup picks the hashers passwords are stored and verified with and builds
+// the policy new passwords are checked against. Hashes made by the algorithm
+// that is not preferred keep verifying and are upgraded on sign-in.
+func (c PasswordConfig) setup() (*password.Hashers, password.Policy, error) {
+	argon2id := password.Argon2id{Memory: 64 * 1024, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+	bcrypt := password.Bcrypt{Cost: bcryptCost}
+	policy := password.Policy{
+		MinLength: c.MinLength,
+		History:   c.History,
+	}
+
+	var hashers *password.Hashers
+	switch c.Hash {
+	case "argon2id":
+		hashers = &password.Hashers{Preferred: argon2id, Accepted: []password.Hasher{bcrypt}}
+	case "bcrypt":
+		//for deployments that cannot spare argon2id's memory
+		hashers = &password.Hashers{Preferred: bcrypt, Accepted: []password.Hasher{argon2id}}
+		policy.MaxLength = password.BcryptMaxLength
+	default:
+		return nil, password.Policy{}, fmt.Errorf("unknown password hash %q", c.Hash)
+	}
+	if c.BreachedFile != "" {
+		breached, err := password.LoadBreached(c.BreachedFile)
+		if err != nil {
+			return nil, password.Policy{}, err
+		}
+		policy.Breached = breached
+	}
+	return hashers, policy, nil
+}
+
+// checkNewPassword records problems with a password a user is choosing in v.
+func (app *application) checkNewPassword(v *validator.Validator, field, plainText string) {
+	app.PasswordPolicy.Check(v, field, plainText)
+}
+
+// checkPasswordReuse refuses plainText when it is one of the user's last
+// passwords.
+func (app *application) checkPasswordReuse(ctx context.Context, repo repository.DatabaseRepo, userID int, field, plainText string) error {
+	if app.PasswordPolicy.History <= 0 {
+		return nil
+	}
+	previous, err := repo.PasswordHistory(ctx, userID, app.PasswordPolicy.History)
+	if err != nil {
+		return err
+	}
+	reused, err := app.PasswordPolicy.Reused(app.PasswordHashers, plainText, previous)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	v := validator.New()
+	v.Check(!reused, field, fmt.Sprintf("must not be one of your last %d passwords", app.PasswordPolicy.History))
+	return v.Err()
+}
+
+// rehashPassword stores a hash made by the preferred hasher for a user who
+// just signed in with a password hashed by an older one. Failures only delay
+// the upgrade to the next sign-in.
+func (app *application) rehashPassword(ctx context.Context, user *models.User, plainText string) {
+	oldHash := user.Password
+	err := user.SetPassword(app.PasswordHashers, plainText)
+	if err != nil {
+		log.Println("rehashing password:", err)
+		return
+	}
+	err = app.DB.RehashUserPassword(ctx, int(user.ID), oldHash, user.Password)
+	if err != nil {
+		log.Println("rehashing password:", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"go-restapi/inernal/password"
+	"testing"
+)
+
+func TestPasswordConfigSetup(t *testing.T) {
+	hashers, policy, err := PasswordConfig{Hash: "argon2id", MinLength: 10, History: 3}.setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hashers.Preferred.(password.Argon2id); !ok {
+		t.Errorf("preferred hasher is %T, want Argon2id", hashers.Preferred)
+	}
+	if policy.MinLength != 10 || policy.History != 3 || policy.MaxLength != 0 {
+		t.Errorf("argon2id policy = %+v, want no maximum length", policy)
+	}
+
+	hashers, policy, err = PasswordConfig{Hash: "bcrypt", MinLength: 8}.setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hashers.Preferred.(password.Bcrypt); !ok {
+		t.Errorf("preferred hasher is %T, want Bcrypt", hashers.Preferred)
+	}
+	if policy.MaxLength != password.BcryptMaxLength {
+		t.Errorf("bcrypt policy MaxLength = %d, want %d", policy.MaxLength, password.BcryptMaxLength)
+	}
+
+	_, _, err = PasswordConfig{Hash: "md5"}.setup()
+	if err == nil {
+		t.Error("unknown hash accepted")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/mailer"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/repository"
+	"go-restapi/inernal/validator"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	passwordResetTTL            = time.Hour
+	passwordResetResendInterval = 5 * time.Minute
+	mailTimeout                 = 30 * time.Second
+)
+
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	//the response is the same whether or not the account exists, so this
+	//endpoint cannot be used to find out which emails are registered
+	resp := JSONResponse{
+		Error:   false,
+		Message: "if an account exists for this email, a reset link has been sent",
+	}
+
+	//every request counts against the client's address, whether or not the
+	//account exists; a throttled one is answered like any other but sends nothing
+	ip := clientIP(r)
+	wait, err := app.loginLimits.resetIP.Check(r.Context(), ip)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+	_, err = app.loginLimits.resetIP.Fail(r.Context(), ip)
+	if err != nil {
+		log.Println("recording password reset request:", err)
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), models.NormalizeEmail(requestPayload.Email))
+	if err != nil {
+		if apperror.Is(err, apperror.KindNotFound) {
+			app.writeJSON(w, http.StatusAccepted, resp)
+			return
+		}
+		app.errorJSON(w, r, err)
+		return
+	}
+	sent, err := app.DB.TouchPasswordResetSent(r.Context(), int(user.ID), passwordResetResendInterval)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !sent {
+		app.writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+
+	token, hash, err := models.GenerateToken()
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	err = app.DB.InsertPasswordReset(r.Context(), models.PasswordReset{
+		UserID:    int(user.ID),
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", app.FrontendURL, url.QueryEscape(token))
+	app.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %s.\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n", user.FirstName, passwordResetTTL, link),
+	})
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(requestPayload.Token != "", "token", "must be provided")
+	app.checkNewPassword(v, "password", requestPayload.Password)
+	err = v.Err()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	var user models.User
+	err = user.SetPassword(app.PasswordHashers, requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+
+	//consuming the token, changing the password and signing out every
+	//session succeed or fail together
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		userID, err := repo.ConsumePasswordReset(r.Context(), models.HashToken(requestPayload.Token))
+		if err != nil {
+			return err
+		}
+		//a reused password rolls back the token so the user can try another
+		err = app.checkPasswordReuse(r.Context(), repo, userID, "password", requestPayload.Password)
+		if err != nil {
+			return err
+		}
+		err = repo.UpdateUserPassword(r.Context(), userID, user.Password)
+		if err != nil {
+			return err
+		}
+		return repo.RevokeUserTokens(r.Context(), userID)
+	})
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password has been reset!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// sendMail delivers msg in the background so slow mail servers do not hold
+// up the response, logging any failure.
+func (app *application) sendMail(msg mailer.Message) {
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Println("sending mail:", p)
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		err := app.Mailer.Send(ctx, msg)
+		if err != nil {
+			log.Println("sending mail:", err)
+		}
+	}()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func forgotPassword(t *testing.T, app *application, email string) (int, string) {
+	t.Helper()
+	w := app.do(t, testRequest{method: "POST", path: "/password/forgot", body: `{"email":"` + email + `"}`})
+	return w.Code, w.Body.String()
+}
+
+func TestForgotPasswordThrottlesPerAccount(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	db.addUser("bob@example.com", "correct horse")
+
+	status, body := forgotPassword(t, app, "ann@example.com")
+	if status != http.StatusAccepted {
+		t.Fatalf("forgot password: status %d", status)
+	}
+	mailTo(t, app, "ann@example.com")
+
+	//a repeat and an unknown address answer exactly like the first request
+	for _, email := range []string{"ann@example.com", "Ann@Example.com", "nobody@example.com"} {
+		if s, b := forgotPassword(t, app, email); s != status || b != body {
+			t.Errorf("%s: status %d %s, want %d %s", email, s, b, status, body)
+		}
+	}
+	noMail(t, app)
+	if len(db.resets) != 1 {
+		t.Errorf("%d resets stored, want 1", len(db.resets))
+	}
+
+	//other accounts are not held up
+	forgotPassword(t, app, "bob@example.com")
+	mailTo(t, app, "bob@example.com")
+}
+
+func TestForgotPasswordThrottlesPerIP(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	app.loginLimits.resetIP.Policy.Threshold = 3
+
+	//requests for unknown addresses count too
+	var body string
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		_, body = forgotPassword(t, app, email)
+	}
+	status, b := forgotPassword(t, app, "ann@example.com")
+	if status != http.StatusAccepted || b != body {
+		t.Errorf("throttled request: status %d %s, want 202 %s", status, b, body)
+	}
+	noMail(t, app)
+	if len(db.resets) != 0 {
+		t.Errorf("%d resets stored, want none", len(db.resets))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go-restapi/inernal/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID  int
+	Name    string
+	Roles   []string
+	Scopes  []string
+	TokenID string
+	// SessionID is the session the access token was issued for.
+	SessionID string
+	// Actor is set when an admin is impersonating the user.
+	Actor *Actor
+	// APIKeyID is set when the caller authenticated with an API key rather
+	// than a user's access token.
+	APIKeyID int
+}
+
+// Impersonated reports whether someone else is acting as the user.
+func (p *Principal) Impersonated() bool {
+	return p.Actor != nil
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey string
+
+const principalContextKey = contextKey("principal")
+
+// principalFromClaims builds the principal an access token was issued to.
+func principalFromClaims(claims *Claims) (*Principal, error) {
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, errors.New("unknown user")
+	}
+	return &Principal{
+		UserID:    userID,
+		Name:      claims.Name,
+		Roles:     claims.Roles,
+		Scopes:    strings.Fields(claims.Scope),
+		TokenID:   claims.ID,
+		SessionID: claims.SessionID,
+		Actor:     claims.Actor,
+	}, nil
+}
+
+// principalFromAPIKey builds the principal a service calling with key acts as.
+// The key keeps only the scopes its creator, who holds permissions, still
+// has, so taking a role away also takes it away from their keys.
+func principalFromAPIKey(key *models.APIKey, permissions []string) *Principal {
+	held := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		held[permission] = true
+	}
+	scopes := []string{}
+	for _, scope := range key.Scopes {
+		if held[scope] {
+			scopes = append(scopes, scope)
+		}
+	}
+	return &Principal{
+		UserID:   key.UserID,
+		Name:     key.Name,
+		Scopes:   scopes,
+		APIKeyID: key.ID,
+	}
+}
+
+func contextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// principalFromContext returns the caller stored by authRequired, if any.
+func principalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(*Principal)
+	return principal, ok
+}
+
+// principal returns the caller of a request that went through authRequired.
+func (app *application) principal(r *http.Request) *Principal {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		panic("principal requested on a route without authRequired")
+	}
+	return principal
+}
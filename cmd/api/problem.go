@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"go-restapi/inernal/apperror"
+	"net/http"
+)
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type     string                `json:"type"`
+	Title    string                `json:"title"`
+	Status   int                   `json:"status"`
+	Detail   string                `json:"detail,omitempty"`
+	Instance string                `json:"instance,omitempty"`
+	Code     string                `json:"code"`
+	TraceID  string                `json:"trace_id,omitempty"`
+	Errors   []apperror.FieldError `json:"errors,omitempty"`
+}
+
+func (app *application) newProblem(r *http.Request, status int, code, detail string, fields []apperror.FieldError) Problem {
+	title := http.StatusText(status)
+	if title == "" {
+		title = "Client Closed Request"
+	}
+	return Problem{
+		Type:     fmt.Sprintf("https://%s/problems/%s", app.Domain, code),
+		Title:    title,
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.RequestURI(),
+		Code:     code,
+		TraceID:  middleware.GetReqID(r.Context()),
+		Errors:   fields,
+	}
+}
+
+func (app *application) writeProblem(w http.ResponseWriter, problem Problem) error {
+	out, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	proxies, err := parseProxies("10.0.0.0/8, 192.168.1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{trustedProxies: proxies}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		realIP string
+		want   string
+	}{
+		{"no proxy", "203.0.113.7:1234", "", "", "203.0.113.7:1234"},
+		{"spoofed header from an untrusted peer", "203.0.113.7:1234", "198.51.100.1", "198.51.100.2", "203.0.113.7:1234"},
+		{"trusted proxy", "10.0.0.5:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"client prepends a fake hop", "10.0.0.5:1234", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.5:1234", "198.51.100.1, 192.168.1.10, 10.1.2.3", "", "198.51.100.1"},
+		{"X-Real-IP fallback", "192.168.1.10:1234", "", "198.51.100.2", "198.51.100.2"},
+		{"malformed hop", "10.0.0.5:1234", "198.51.100.1, garbage", "", "10.0.0.5:1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := app.realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %s, want %s", got, tt.want)
+			}
+			if ip := clientIP(&http.Request{RemoteAddr: got}); ip == "" {
+				t.Errorf("clientIP(%s) is empty", got)
+			}
+		})
+	}
+}
+
+func TestRealIPTrustsNoOneByDefault(t *testing.T) {
+	app := &application{}
+	var got string
+	handler := app.realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RemoteAddr
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.5:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "10.0.0.5:1234" {
+		t.Errorf("RemoteAddr = %s, want the peer address", got)
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	proxies, err := parseProxies(" 10.0.0.1 , ::1, 172.16.0.0/12,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 3 {
+		t.Fatalf("got %d networks, want 3", len(proxies))
+	}
+	app := &application{trustedProxies: proxies}
+	for addr, want := range map[string]bool{
+		"10.0.0.1":   true,
+		"10.0.0.2":   false,
+		"::1":        true,
+		"172.20.1.1": true,
+		"not an ip":  false,
+	} {
+		if got := app.trustedProxy(addr); got != want {
+			t.Errorf("trustedProxy(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	for _, list := range []string{"10.0.0", "10.0.0.0/33", "proxy.example.com"} {
+		if _, err := parseProxies(list); err == nil {
+			t.Errorf("parseProxies(%q) accepted an invalid entry", list)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/validator"
+	"net/http"
+)
+
+// requirePermission only lets through callers granted permission. It must
+// run after authRequired.
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := principalFromContext(r.Context())
+			if !ok {
+				app.errorJSON(w, r, apperror.Unauthorized("unauthorized"))
+				return
+			}
+			if !principal.HasScope(permission) {
+				app.errorJSON(w, r, apperror.Forbidden("missing permission "+permission))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// jwtUserFor loads the roles and permissions that go into a user's tokens.
+func (app *application) jwtUserFor(ctx context.Context, user *models.User) (*jwtUser, error) {
+	roles, err := app.DB.UserRoles(ctx, int(user.ID))
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := app.DB.UserPermissions(ctx, int(user.ID))
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled && app.needsMFA(roles) {
+		roles, permissions, err = app.rolesWithoutMFA(ctx, roles)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &jwtUser{
+		ID:          user.ID,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Roles:       roles,
+		Permissions: permissions,
+	}, nil
+}
+
+func (app *application) needsMFA(roles []string) bool {
+	for _, role := range roles {
+		if app.mfaRoles[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// rolesWithoutMFA drops the roles that require two-factor authentication and
+// returns the permissions the remaining roles still grant, so a user who has
+// not enrolled yet can sign in and enroll without those rights.
+func (app *application) rolesWithoutMFA(ctx context.Context, roles []string) ([]string, []string, error) {
+	all, err := app.DB.AllRoles(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var kept []string
+	for _, role := range roles {
+		if !app.mfaRoles[role] {
+			kept = append(kept, role)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, role := range all {
+		if app.mfaRoles[role.Name] || !validator.In(role.Name, kept...) {
+			continue
+		}
+		for _, permission := range role.Permissions {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return kept, permissions, nil
+}
+
+func (app *application) AllRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.DB.AllRoles(r.Context())
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, roles)
+}
+
+func (app *application) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	var requestPayload struct {
+		Roles []string `json:"roles"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	var roles []string
+	for _, role := range requestPayload.Roles {
+		if !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	//unverified accounts cannot be given anything beyond the default role
+	user, err := app.DB.GetUserById(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if !user.Verified() {
+		for _, role := range roles {
+			if role != defaultRole {
+				app.errorJSON(w, r, errEmailNotVerified)
+				return
+			}
+		}
+	}
+
+	err = app.DB.SetUserRoles(r.Context(), userId, roles)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "roles updated!",
+		Data:    roles,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
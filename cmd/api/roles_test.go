@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"go-restapi/inernal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// addUserWithRoles stores a user holding roles and their permissions.
+func addUserWithRoles(t *testing.T, db *fakeDB, email string, roles ...string) *models.User {
+	t.Helper()
+	user := db.addUser(email, "correct horse")
+	err := db.SetUserRoles(context.Background(), int(user.ID), roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// movieWrites are requests behind movies:write and genres:write. Their ids and
+// bodies are invalid, so a request that gets past the permission check fails
+// in the handler without touching the database.
+var movieWrites = []testRequest{
+	{method: "PUT", path: "/admin/movie/0", body: "{"},
+	{method: "PUT", path: "/admin/movies/x", body: "{}"},
+	{method: "PATCH", path: "/admin/movies/x", body: "{}"},
+	{method: "DELETE", path: "/admin/movies/x"},
+	{method: "POST", path: "/admin/genres", body: "{"},
+	{method: "PUT", path: "/admin/genres/x", body: "{}"},
+	{method: "DELETE", path: "/admin/genres/x"},
+}
+
+func TestRequirePermission(t *testing.T) {
+	app, db := newTestApp(t)
+	addUserWithRoles(t, db, "viewer@example.com", "viewer")
+	addUserWithRoles(t, db, "editor@example.com", "editor")
+	viewer, _ := app.signIn(t, "viewer@example.com", "correct horse")
+	editor, _ := app.signIn(t, "editor@example.com", "correct horse")
+
+	for _, req := range movieWrites {
+		req.token = ""
+		if w := app.do(t, req); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token: status %d, want 401", req.method, req.path, w.Code)
+		}
+		req.token = viewer
+		if w := app.do(t, req); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s as viewer: status %d, want 403", req.method, req.path, w.Code)
+		}
+		req.token = editor
+		if w := app.do(t, req); w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+			t.Errorf("%s %s as editor: status %d", req.method, req.path, w.Code)
+		}
+	}
+
+	//managing users needs users:write, which editors do not have
+	w := app.do(t, testRequest{method: "PUT", path: "/admin/users/1/roles", body: `{"roles":["admin"]}`, token: editor})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("editor assigning roles: status %d, want 403", w.Code)
+	}
+	if roles, _ := db.UserRoles(context.Background(), 1); len(roles) != 1 || roles[0] != "viewer" {
+		t.Errorf("roles changed to %v", roles)
+	}
+}
+
+func TestSetUserRolesUpdatesClaims(t *testing.T) {
+	app, db := newTestApp(t)
+	addUserWithRoles(t, db, "admin@example.com", "admin")
+	user := addUserWithRoles(t, db, "ann@example.com", "viewer")
+	admin, _ := app.signIn(t, "admin@example.com", "correct horse")
+	_, cookie := app.signIn(t, "ann@example.com", "correct horse")
+	path := "/admin/users/" + strconv.Itoa(int(user.ID)) + "/roles"
+
+	//refresh picks up the roles assigned since the last token was issued
+	refresh := func() (string, *Claims) {
+		t.Helper()
+		w := app.do(t, testRequest{method: "POST", path: "/refresh", cookie: cookie})
+		if w.Code != http.StatusOK {
+			t.Fatalf("refreshing: status %d: %s", w.Code, w.Body)
+		}
+		cookie = refreshCookie(t, app, w)
+		var tokens struct {
+			AccessToken string `json:"access_token"`
+		}
+		decode(t, w, &tokens)
+		claims, err := app.auth.VerifyToken(tokens.AccessToken, tokenTypeAccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokens.AccessToken, claims
+	}
+	deleteMovie := testRequest{method: "DELETE", path: "/admin/movies/x"}
+
+	w := app.do(t, testRequest{method: "PUT", path: path, body: `{"roles":["editor"]}`, token: admin})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("assigning editor: status %d: %s", w.Code, w.Body)
+	}
+	token, claims := refresh()
+	if len(claims.Roles) != 1 || claims.Roles[0] != "editor" || !strings.Contains(claims.Scope, models.PermissionMoviesWrite) {
+		t.Errorf("claims after assigning editor: roles %v, scope %q", claims.Roles, claims.Scope)
+	}
+	deleteMovie.token = token
+	if w := app.do(t, deleteMovie); w.Code == http.StatusForbidden {
+		t.Error("editor refused a movie write")
+	}
+
+	w = app.do(t, testRequest{method: "PUT", path: path, body: `{"roles":["viewer"]}`, token: admin})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("assigning viewer: status %d: %s", w.Code, w.Body)
+	}
+	token, claims = refresh()
+	if len(claims.Roles) != 1 || claims.Roles[0] != "viewer" || strings.Contains(claims.Scope, models.PermissionMoviesWrite) {
+		t.Errorf("claims after assigning viewer: roles %v, scope %q", claims.Roles, claims.Scope)
+	}
+	deleteMovie.token = token
+	if w := app.do(t, deleteMovie); w.Code != http.StatusForbidden {
+		t.Errorf("viewer deleting a movie: status %d, want 403", w.Code)
+	}
+
+	w = app.do(t, testRequest{method: "PUT", path: path, body: `{"roles":["overlord"]}`, token: admin})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unknown role: status %d, want 422", w.Code)
+	}
+}
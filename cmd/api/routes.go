@@ -3,25 +3,94 @@ package main
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"go-restapi/inernal/models"
 	"net/http"
 )
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
+	mux.Use(middleware.RequestID)
+	mux.Use(app.realIP)
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.enableCors)
 	mux.Get("/", app.Home)
+	mux.Get("/.well-known/jwks.json", app.JWKS)
+	mux.Post("/register", app.Register)
+	mux.Get("/verify", app.VerifyEmail)
+	mux.Post("/verify/resend", app.ResendVerification)
 	mux.Post("/authenticate", app.authenticate)
-	mux.Get("/refresh", app.refreshToken)
+	mux.Post("/authenticate/2fa", app.authenticateMFA)
+	mux.Post("/refresh", app.refreshToken)
+	mux.Get("/auth/oidc/{provider}/start", app.OIDCStart)
+	mux.Get("/auth/oidc/{provider}/callback", app.OIDCCallback)
+	mux.Post("/password/forgot", app.ForgotPassword)
+	mux.Post("/password/reset", app.ResetPassword)
 	mux.Get("/logout", app.logout)
 	mux.Get("/movies", app.AllMovies)
+	mux.Get("/movies/search", app.SearchMovies)
 	mux.Get("/movies/{id}", app.GetMovie)
 	mux.Get("/genres", app.AllGenres)
+	mux.Route("/oauth", func(mux chi.Router) {
+		mux.Use(app.clientRequired)
+		mux.Post("/introspect", app.Introspect)
+		mux.Post("/revoke", app.Revoke)
+	})
+	mux.Route("/me", func(mux chi.Router) {
+		mux.Use(app.authRequired)
+		mux.Use(app.userRequired)
+		mux.Get("/", app.GetMe)
+		mux.Get("/sessions", app.MySessions)
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.refuseImpersonation)
+			mux.Patch("/", app.UpdateMe)
+			mux.Post("/password", app.ChangePassword)
+			mux.Post("/2fa/totp", app.EnrollTOTP)
+			mux.Post("/2fa/totp/confirm", app.ConfirmTOTP)
+			mux.Delete("/2fa/totp", app.DisableTOTP)
+			mux.Delete("/sessions", app.RevokeMyOtherSessions)
+			mux.Delete("/sessions/{id}", app.RevokeMySession)
+		})
+	})
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(app.authRequired)
-		mux.Get("/movies", app.MovieCatalog)
-		mux.Put("/movie/0", app.InsertMovie)
-		mux.Get("/movies/{id}", app.MovieForEdit)
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requirePermission(models.PermissionMoviesRead))
+			mux.Get("/movies", app.MovieCatalog)
+			mux.Get("/movies/{id}", app.MovieForEdit)
+		})
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requirePermission(models.PermissionMoviesWrite))
+			mux.Put("/movie/0", app.InsertMovie)
+			mux.Put("/movies/{id}", app.UpdateMovie)
+			mux.Patch("/movies/{id}", app.UpdateMovie)
+			mux.Delete("/movies/{id}", app.DeleteMovie)
+		})
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requirePermission(models.PermissionGenresWrite))
+			mux.Post("/genres", app.InsertGenre)
+			mux.Put("/genres/{id}", app.UpdateGenre)
+			mux.Delete("/genres/{id}", app.DeleteGenre)
+		})
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requirePermission(models.PermissionUsersWrite))
+			mux.Use(app.refuseImpersonation)
+			mux.Get("/roles", app.AllRoles)
+			mux.Put("/users/{id}/roles", app.SetUserRoles)
+			mux.Get("/users/{id}/sessions", app.UserSessions)
+			mux.Delete("/users/{id}/sessions", app.RevokeUserSessions)
+			mux.Delete("/users/{id}/sessions/{sessionId}", app.RevokeUserSession)
+			mux.Post("/users/{id}/unlock", app.UnlockUser)
+			mux.Get("/api-keys", app.AllAPIKeys)
+			mux.Post("/api-keys", app.InsertAPIKey)
+			mux.Delete("/api-keys/{id}", app.RevokeAPIKey)
+			mux.Get("/users/{id}/impersonations", app.UserImpersonations)
+		})
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requirePermission(models.PermissionUsersImpersonate))
+			mux.Use(app.userRequired)
+			mux.Use(app.refuseImpersonation)
+			mux.Post("/users/{id}/impersonate", app.Impersonate)
+		})
 	})
 	return mux
 }
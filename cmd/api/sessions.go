@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/go-chi/chi/v5"
+	"go-restapi/inernal/models"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// maxUserAgentLength matches the sessions.user_agent column.
+const maxUserAgentLength = 512
+
+// newSession describes the session a sign-in from r starts.
+func newSession(r *http.Request, id string, userID int) models.Session {
+	now := time.Now()
+	return models.Session{
+		ID:         id,
+		UserID:     userID,
+		UserAgent:  userAgent(r),
+		IP:         clientIP(r),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+}
+
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if utf8.RuneCountInString(ua) > maxUserAgentLength {
+		ua = string([]rune(ua)[:maxUserAgentLength])
+	}
+	return ua
+}
+
+func (app *application) MySessions(w http.ResponseWriter, r *http.Request) {
+	principal := app.principal(r)
+	sessions, err := app.DB.UserSessions(r.Context(), principal.UserID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == principal.SessionID
+	}
+	_ = app.writeJSON(w, http.StatusOK, sessions)
+}
+
+func (app *application) RevokeMySession(w http.ResponseWriter, r *http.Request) {
+	err := app.DB.RevokeSession(r.Context(), app.principal(r).UserID, chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "session revoked!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// RevokeMyOtherSessions logs the caller out everywhere except the session
+// the request was made from.
+func (app *application) RevokeMyOtherSessions(w http.ResponseWriter, r *http.Request) {
+	principal := app.principal(r)
+	err := app.DB.RevokeOtherSessions(r.Context(), principal.UserID, principal.SessionID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "other sessions revoked!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (app *application) UserSessions(w http.ResponseWriter, r *http.Request) {
+	userId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	sessions, err := app.DB.UserSessions(r.Context(), userId)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, sessions)
+}
+
+func (app *application) RevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	userId, err := readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	err = app.DB.RevokeSession(r.Context(), userId, chi.URLParam(r, "sessionId"))
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "session revoked!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// sessionOf returns the session an access token was issued for.
+func sessionOf(t *testing.T, app *application, token string) string {
+	t.Helper()
+	claims, err := app.auth.VerifyToken(token, tokenTypeAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims.SessionID
+}
+
+func TestRevokedSessionRejectsAccessToken(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	laptop, _ := app.signIn(t, "ann@example.com", "correct horse")
+	phone, phoneCookie := app.signIn(t, "ann@example.com", "correct horse")
+
+	w := app.do(t, testRequest{method: "DELETE", path: "/me/sessions/" + sessionOf(t, app, phone), token: laptop})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("revoking session: status %d: %s", w.Code, w.Body)
+	}
+
+	w = app.do(t, testRequest{method: "GET", path: "/me/", token: phone})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("access token of the revoked session: status %d, want 401", w.Code)
+	}
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: phoneCookie})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh of the revoked session: status %d, want 401", w.Code)
+	}
+	w = app.do(t, testRequest{method: "GET", path: "/me/", token: laptop})
+	if w.Code != http.StatusOK {
+		t.Errorf("access token of another session: status %d, want 200", w.Code)
+	}
+}
+
+func TestRevokeOtherSessionsKeepsCurrent(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("ann@example.com", "correct horse")
+	laptop, laptopCookie := app.signIn(t, "ann@example.com", "correct horse")
+	phone, _ := app.signIn(t, "ann@example.com", "correct horse")
+	tablet, _ := app.signIn(t, "ann@example.com", "correct horse")
+
+	w := app.do(t, testRequest{method: "DELETE", path: "/me/sessions", token: laptop})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("revoking sessions: status %d: %s", w.Code, w.Body)
+	}
+
+	for name, token := range map[string]string{"phone": phone, "tablet": tablet} {
+		w = app.do(t, testRequest{method: "GET", path: "/me/", token: token})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("access token from %s: status %d, want 401", name, w.Code)
+		}
+	}
+	w = app.do(t, testRequest{method: "GET", path: "/me/", token: laptop})
+	if w.Code != http.StatusOK {
+		t.Errorf("current access token: status %d, want 200", w.Code)
+	}
+	w = app.do(t, testRequest{method: "POST", path: "/refresh", cookie: laptopCookie})
+	if w.Code != http.StatusOK {
+		t.Errorf("current refresh token: status %d, want 200", w.Code)
+	}
+}
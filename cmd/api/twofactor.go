@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/totp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+var (
+	errInvalidSecondFactor = apperror.Unauthorized("invalid two-factor code").WithCode("invalid_mfa_code")
+	errTOTPAlreadyEnabled  = apperror.Conflict("two-factor authentication is already enabled")
+)
+
+// MFAChallenge is sent by authenticate instead of tokens when the account
+// has a second factor.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// mfaChallenge answers a correct first factor with an mfa token to finish
+// signing in at /authenticate/2fa.
+func (app *application) mfaChallenge(w http.ResponseWriter, r *http.Request, user *models.User) {
+	mfaToken, err := app.auth.GenerateMFAToken(user.ID, mfaTokenTTL)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	resp := MFAChallenge{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	}
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (app *application) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.DB.GetUserById(r.Context(), app.principal(r).UserID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if user.TOTPEnabled {
+		app.errorJSON(w, r, errTOTPAlreadyEnabled)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	err = app.DB.SetTOTPSecret(r.Context(), int(user.ID), secret)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	resp := struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(app.JWTIssuer, user.Email, secret),
+	}
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := app.principal(r).UserID
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	enrollment, err := app.DB.GetTOTP(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if enrollment.Enabled() {
+		app.errorJSON(w, r, errTOTPAlreadyEnabled)
+		return
+	}
+	step, ok := totp.Validate(enrollment.Secret, requestPayload.Code, time.Now())
+	if !ok {
+		app.errorJSON(w, r, errInvalidSecondFactor)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	err = app.DB.EnableTOTP(r.Context(), userID, step, hashes)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	//recovery codes are only ever shown here, the database keeps their hashes
+	resp := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// DisableTOTP turns the second factor off. It asks for the password as well
+// as a code, and wrong guesses count against the sign-in limits, so a stolen
+// access token is not enough to strip the second factor.
+func (app *application) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := app.principal(r).UserID
+	var requestPayload struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	user, err := app.DB.GetUserById(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	ip := clientIP(r)
+	err = app.loginLimits.check(r.Context(), user.Email, ip)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	valid, err := user.PasswordMatches(app.PasswordHashers, requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	if !valid {
+		app.loginLimits.fail(r.Context(), user.Email, ip)
+		app.errorJSON(w, r, apperror.Unauthorized("current password is incorrect"))
+		return
+	}
+	err = app.checkSecondFactor(r.Context(), userID, requestPayload.Code, requestPayload.RecoveryCode)
+	if err != nil {
+		if errors.Is(err, errInvalidSecondFactor) {
+			app.loginLimits.fail(r.Context(), user.Email, ip)
+		}
+		app.errorJSON(w, r, err)
+		return
+	}
+	app.loginLimits.succeed(r.Context(), user.Email)
+
+	err = app.DB.DisableTOTP(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "two-factor authentication disabled!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// authenticateMFA is the second step of signing in to an account with a
+// second factor. It trades the mfa token from authenticate and a current
+// code, or an unused recovery code, for real tokens.
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	claims, err := app.auth.VerifyToken(requestPayload.MFAToken, tokenTypeMFA)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Unauthorized("unauthorized"))
+		return
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Unauthorized("unauthorized"))
+		return
+	}
+	user, err := app.DB.GetUserById(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Unauthorized("unauthorized"))
+		return
+	}
+
+	//second factor guesses count against the same limits as passwords
+	ip := clientIP(r)
+	err = app.loginLimits.check(r.Context(), user.Email, ip)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	err = app.checkSecondFactor(r.Context(), userID, requestPayload.Code, requestPayload.RecoveryCode)
+	if err != nil {
+		if errors.Is(err, errInvalidSecondFactor) {
+			app.loginLimits.fail(r.Context(), user.Email, ip)
+		}
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.loginLimits.succeed(r.Context(), user.Email)
+	app.issueSession(w, r, user)
+}
+
+// checkSecondFactor accepts either a TOTP code, which cannot be used twice,
+// or one of the user's recovery codes, which is spent.
+func (app *application) checkSecondFactor(ctx context.Context, userID int, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		ok, err := app.DB.UseRecoveryCode(ctx, userID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+
+	enrollment, err := app.DB.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !enrollment.Enabled() {
+		return errInvalidSecondFactor
+	}
+	step, ok := totp.Validate(enrollment.Secret, code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+	ok, err = app.DB.UseTOTPStep(ctx, userID, step)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errInvalidSecondFactor
+	}
+	return nil
+}
+
+// generateRecoveryCodes returns n codes formatted as xxxx-xxxx and their hashes.
+func generateRecoveryCodes(n int) ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, dashes and spaces so codes can be typed the
+// way they were written down.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return models.HashToken(code)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"go-restapi/inernal/totp"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// mfaToken signs in to an account with a second factor and returns the mfa
+// token that finishes the sign-in.
+func (app *application) mfaToken(t *testing.T, email, password string) string {
+	t.Helper()
+	w := app.do(t, testRequest{
+		method: "POST",
+		path:   "/authenticate",
+		body:   `{"email":"` + email + `","password":"` + password + `"}`,
+	})
+	var challenge MFAChallenge
+	decode(t, w, &challenge)
+	if w.Code != http.StatusAccepted || !challenge.MFARequired {
+		t.Fatalf("signing in: status %d: %s", w.Code, w.Body)
+	}
+	return challenge.MFAToken
+}
+
+func currentCode(t *testing.T) string {
+	t.Helper()
+	code, err := totp.Code(testTOTPSecret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestRecoveryCodeIsSpent(t *testing.T) {
+	app, db := newTestApp(t)
+	user := db.addUser("ann@example.com", "correct horse")
+	codes, hashes, err := generateRecoveryCodes(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.enableTOTP(int(user.ID), testTOTPSecret, hashes...)
+
+	use := func(code string) int {
+		t.Helper()
+		token := app.mfaToken(t, "ann@example.com", "correct horse")
+		w := app.do(t, testRequest{
+			method: "POST",
+			path:   "/authenticate/2fa",
+			body:   `{"mfa_token":"` + token + `","recovery_code":"` + code + `"}`,
+		})
+		return w.Code
+	}
+
+	if status := use(codes[0]); status != http.StatusAccepted {
+		t.Fatalf("first use: status %d", status)
+	}
+	if status := use(codes[0]); status != http.StatusUnauthorized {
+		t.Fatalf("second use: status %d, want 401", status)
+	}
+	if status := use(codes[1]); status != http.StatusAccepted {
+		t.Fatalf("other code: status %d", status)
+	}
+}
+
+func TestDisableTOTPRequiresPassword(t *testing.T) {
+	app, db := newTestApp(t)
+	user := db.addUser("ann@example.com", "correct horse")
+	token, _ := app.signIn(t, "ann@example.com", "correct horse")
+	db.enableTOTP(int(user.ID), testTOTPSecret)
+
+	for _, body := range []string{
+		`{"code":"` + currentCode(t) + `"}`,
+		`{"password":"wrong","code":"` + currentCode(t) + `"}`,
+	} {
+		w := app.do(t, testRequest{method: "DELETE", path: "/me/2fa/totp", token: token, body: body})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", body, w.Code)
+		}
+	}
+	if _, err := db.GetTOTP(context.Background(), int(user.ID)); err != nil {
+		t.Fatal("second factor was disabled without the password")
+	}
+
+	w := app.do(t, testRequest{
+		method: "DELETE",
+		path:   "/me/2fa/totp",
+		token:  token,
+		body:   `{"password":"correct horse","code":"` + currentCode(t) + `"}`,
+	})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if _, err := db.GetTOTP(context.Background(), int(user.ID)); err == nil {
+		t.Error("second factor is still enabled")
+	}
+}
+
+func TestDisableTOTPIsThrottled(t *testing.T) {
+	app, db := newTestApp(t)
+	user := db.addUser("ann@example.com", "correct horse")
+	token, _ := app.signIn(t, "ann@example.com", "correct horse")
+	db.enableTOTP(int(user.ID), testTOTPSecret)
+
+	for i := 0; i < 5; i++ {
+		w := app.do(t, testRequest{
+			method: "DELETE",
+			path:   "/me/2fa/totp",
+			token:  token,
+			body:   `{"password":"correct horse","code":"000000"}`,
+		})
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: status %d, want 401", i+1, w.Code)
+		}
+	}
+
+	//once locked, even the right code is refused
+	w := app.do(t, testRequest{
+		method: "DELETE",
+		path:   "/me/2fa/totp",
+		token:  token,
+		body:   `{"password":"correct horse","code":"` + currentCode(t) + `"}`,
+	})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("locked response has no Retry-After")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/repository"
+	"go-restapi/inernal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultRole = "viewer"
+
+func (app *application) Register(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	user := models.User{
+		FirstName: strings.TrimSpace(requestPayload.FirstName),
+		LastName:  strings.TrimSpace(requestPayload.LastName),
+		Email:     models.NormalizeEmail(requestPayload.Email),
+		CreatedAt: time.Now(),
+		UpdateAt:  time.Now(),
+	}
+	v := validator.New()
+	user.Check(v)
+	app.checkNewPassword(v, "password", requestPayload.Password)
+	err = v.Err()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = user.SetPassword(app.PasswordHashers, requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	//self-registered accounts start out as viewers
+	user.Roles = []string{defaultRole}
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		newID, err := repo.InsertUser(r.Context(), user)
+		if err != nil {
+			return err
+		}
+		user.ID = int64(newID)
+		return repo.SetUserRoles(r.Context(), newID, user.Roles)
+	})
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	_, err = app.sendVerificationEmail(r.Context(), &user, verificationResendInterval)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, user)
+}
+
+func (app *application) GetMe(w http.ResponseWriter, r *http.Request) {
+	userID := app.principal(r).UserID
+	user, err := app.DB.GetUserById(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	user.Roles, err = app.DB.UserRoles(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, user)
+}
+
+func (app *application) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	userID := app.principal(r).UserID
+	user, err := app.DB.GetUserById(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	var requestPayload struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Email     *string `json:"email"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if requestPayload.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*requestPayload.FirstName)
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = strings.TrimSpace(*requestPayload.LastName)
+	}
+	emailChanged := false
+	if requestPayload.Email != nil && models.NormalizeEmail(*requestPayload.Email) != user.Email {
+		//a new address has to be confirmed again
+		user.Email = models.NormalizeEmail(*requestPayload.Email)
+		user.VerifiedAt = nil
+		emailChanged = true
+	}
+	v := validator.New()
+	user.Check(v)
+	err = v.Err()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	user.UpdateAt = time.Now()
+	err = app.DB.UpdateUser(r.Context(), *user)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	//the new address always gets a link, however recently the old one did;
+	//without it the user could not sign in again
+	if emailChanged {
+		_, err = app.sendVerificationEmail(r.Context(), user, 0)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+	}
+	_ = app.writeJSON(w, http.StatusOK, user)
+}
+
+func (app *application) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := app.principal(r).UserID
+	var requestPayload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	user, err := app.DB.GetUserById(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	//guesses at the current password count against the same limits as sign-in
+	ip := clientIP(r)
+	err = app.loginLimits.check(r.Context(), user.Email, ip)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	valid, err := user.PasswordMatches(app.PasswordHashers, requestPayload.CurrentPassword)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	if !valid {
+		app.loginLimits.fail(r.Context(), user.Email, ip)
+		app.errorJSON(w, r, apperror.Unauthorized("current password is incorrect"))
+		return
+	}
+	app.loginLimits.succeed(r.Context(), user.Email)
+
+	v := validator.New()
+	app.checkNewPassword(v, "new_password", requestPayload.NewPassword)
+	err = v.Err()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	err = app.checkPasswordReuse(r.Context(), app.DB, userID, "new_password", requestPayload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	err = user.SetPassword(app.PasswordHashers, requestPayload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Internal(err))
+		return
+	}
+	err = app.DB.UpdateUserPassword(r.Context(), userID, user.Password)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password changed!",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -3,8 +3,14 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"github.com/go-chi/chi/v5"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/repository"
 	"io"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 )
 
 type JSONResponse struct {
@@ -41,22 +47,97 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data in
 	dec.DisallowUnknownFields()
 	err := dec.Decode(data)
 	if err != nil {
-		return err
+		return apperror.Wrap(apperror.KindBadRequest, err.Error(), err)
 	}
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must contain only single json value")
+		return apperror.BadRequest("body must contain only single json value")
 	}
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
+// readIDParam parses the numeric {id} URL parameter.
+func readIDParam(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return 0, apperror.BadRequest("id must be an integer")
+	}
+	return id, nil
+}
+
+// statusClientClosedRequest is the non-standard status nginx uses when the
+// client went away before the response was ready.
+const statusClientClosedRequest = 499
+
+// errorJSON is the single place errors are turned into responses. Domain errors
+// pick their own status code and only their public message reaches the
+// client; internal errors, and any error that is not a domain error, are
+// logged and replaced by a generic message. Responses are
+// application/problem+json unless the server runs with -legacy-errors.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) error {
+	statusCode, code, message := errorStatus(err)
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
-	var payload JSONResponse
-	payload.Error = true
-	payload.Message = err.Error()
-	return app.writeJSON(w, statusCode, payload)
+	if statusCode >= http.StatusInternalServerError {
+		log.Println(err)
+	}
+	if appErr, ok := apperror.As(err); ok && appErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(appErr.RetryAfter.Seconds()))))
+	}
+
+	if app.LegacyErrors {
+		var payload JSONResponse
+		payload.Error = true
+		payload.Message = message
+		return app.writeJSON(w, statusCode, payload)
+	}
+
+	var fields []apperror.FieldError
+	if appErr, ok := apperror.As(err); ok {
+		fields = appErr.Fields
+	}
+	return app.writeProblem(w, app.newProblem(r, statusCode, code, message, fields))
+}
+
+// errorStatus maps err to an HTTP status code, a machine-readable code and a
+// message safe to send.
+func errorStatus(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, repository.ErrCanceled):
+		return statusClientClosedRequest, "canceled", repository.ErrCanceled.Error()
+	case errors.Is(err, repository.ErrTimeout):
+		return http.StatusServiceUnavailable, "timeout", repository.ErrTimeout.Error()
+	}
+
+	appErr, ok := apperror.As(err)
+	if !ok {
+		return http.StatusInternalServerError, apperror.KindInternal.String(), http.StatusText(http.StatusInternalServerError)
+	}
+	code := appErr.ErrorCode()
+	switch appErr.Kind {
+	case apperror.KindBadRequest:
+		return http.StatusBadRequest, code, appErr.Message
+	case apperror.KindNotFound:
+		return http.StatusNotFound, code, appErr.Message
+	case apperror.KindConflict:
+		return http.StatusConflict, code, appErr.Message
+	case apperror.KindValidation:
+		return http.StatusUnprocessableEntity, code, appErr.Message
+	case apperror.KindUnauthorized:
+		return http.StatusUnauthorized, code, appErr.Message
+	case apperror.KindForbidden:
+		return http.StatusForbidden, code, appErr.Message
+	case apperror.KindTooManyRequests:
+		return http.StatusTooManyRequests, code, appErr.Message
+	default:
+		return http.StatusInternalServerError, code, http.StatusText(http.StatusInternalServerError)
+	}
+}
+
+// isRepositoryError reports whether err came from the database layer rather
+// than from what the caller sent.
+func isRepositoryError(err error) bool {
+	_, ok := apperror.As(err)
+	return ok || errors.Is(err, repository.ErrCanceled) || errors.Is(err, repository.ErrTimeout)
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/repository"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		message string
+	}{
+		{"unclassified error", errors.New("dial tcp 10.0.0.5:5432: connection refused"), http.StatusInternalServerError, "Internal Server Error"},
+		{"internal error", apperror.Internal(errors.New("pq: relation does not exist")), http.StatusInternalServerError, "Internal Server Error"},
+		{"bad request", apperror.BadRequest("id must be an integer"), http.StatusBadRequest, "id must be an integer"},
+		{"validation", apperror.Validation("request failed validation"), http.StatusUnprocessableEntity, "request failed validation"},
+		{"not found", repository.ErrMovieNotFound, http.StatusNotFound, "movie not found"},
+		{"wrapped domain error", fmt.Errorf("loading: %w", repository.ErrUserNotFound), http.StatusNotFound, "user not found"},
+		{"timeout", fmt.Errorf("%w: context deadline exceeded", repository.ErrTimeout), http.StatusServiceUnavailable, repository.ErrTimeout.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, message := errorStatus(tt.err)
+			if status != tt.status || message != tt.message {
+				t.Errorf("errorStatus() = %d %q, want %d %q", status, message, tt.status, tt.message)
+			}
+		})
+	}
+}
+
+func TestReadJSONErrorsAreBadRequests(t *testing.T) {
+	var app application
+	for _, body := range []string{`{"title":`, `{"unknown":1}`, `{} {}`, `[1]`} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		var payload struct {
+			Title string `json:"title"`
+		}
+		err := app.readJSON(httptest.NewRecorder(), r, &payload)
+		if !apperror.Is(err, apperror.KindBadRequest) {
+			t.Errorf("readJSON(%s) = %v, want a bad request", body, err)
+		}
+	}
+}
+
+func TestReadIDParam(t *testing.T) {
+	r := withURLParams(httptest.NewRequest("GET", "/", nil), map[string]string{"id": "abc"})
+	_, err := readIDParam(r)
+	if !apperror.Is(err, apperror.KindBadRequest) {
+		t.Errorf("readIDParam(abc) = %v, want a bad request", err)
+	}
+
+	r = withURLParams(httptest.NewRequest("GET", "/", nil), map[string]string{"id": "42"})
+	id, err := readIDParam(r)
+	if err != nil || id != 42 {
+		t.Errorf("readIDParam(42) = %d, %v", id, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-restapi/inernal/apperror"
+	"go-restapi/inernal/mailer"
+	"go-restapi/inernal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	verificationTTL            = 24 * time.Hour
+	verificationResendInterval = 5 * time.Minute
+)
+
+var errEmailNotVerified = apperror.Forbidden("email address has not been verified").WithCode("email_not_verified")
+
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		app.errorJSON(w, r, apperror.Validation("verification token is required"))
+		return
+	}
+	claims, err := app.auth.VerifyToken(token, tokenTypeEmailVerification)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Validation("verification link is invalid or has expired"))
+		return
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, r, apperror.Validation("verification link is invalid or has expired"))
+		return
+	}
+	err = app.DB.MarkUserVerified(r.Context(), userID, claims.Email)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	resp := JSONResponse{
+		Error:   false,
+		Message: "email verified!",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "if an unverified account exists for this email, a verification link has been sent",
+	}
+	user, err := app.DB.GetUserByEmail(r.Context(), models.NormalizeEmail(requestPayload.Email))
+	if err != nil {
+		if apperror.Is(err, apperror.KindNotFound) {
+			app.writeJSON(w, http.StatusAccepted, resp)
+			return
+		}
+		app.errorJSON(w, r, err)
+		return
+	}
+	if user.Verified() {
+		app.writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+
+	//a throttled resend answers like every other case so the response does
+	//not tell whether the account exists
+	_, err = app.sendVerificationEmail(r.Context(), user, verificationResendInterval)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// sendVerificationEmail mails the user a verification link unless one was
+// sent within interval, reporting whether it sent one. An interval of zero
+// always sends.
+func (app *application) sendVerificationEmail(ctx context.Context, user *models.User, interval time.Duration) (bool, error) {
+	sent, err := app.DB.TouchVerificationSent(ctx, int(user.ID), interval)
+	if err != nil || !sent {
+		return false, err
+	}
+	token, err := app.auth.GenerateVerificationToken(user.ID, user.Email, verificationTTL)
+	if err != nil {
+		return false, apperror.Internal(err)
+	}
+
+	link := fmt.Sprintf("%s/verify?token=%s", app.APIURL, url.QueryEscape(token))
+	app.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %s.\n\n%s\n",
+			user.FirstName, verificationTTL, link),
+	})
+	return true, nil
+}
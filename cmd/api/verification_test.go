@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResendVerificationDoesNotRevealAccounts(t *testing.T) {
+	app, db := newTestApp(t)
+	db.addUser("verified@example.com", "correct horse")
+	user := db.addUser("new@example.com", "correct horse")
+	user.VerifiedAt = nil
+	_ = db.UpdateUser(context.Background(), *user)
+
+	resend := func(email string) *http.Response {
+		t.Helper()
+		w := app.do(t, testRequest{method: "POST", path: "/verify/resend", body: `{"email":"` + email + `"}`})
+		return w.Result()
+	}
+
+	first := resend("new@example.com")
+	if first.StatusCode != http.StatusAccepted {
+		t.Fatalf("resend: status %d", first.StatusCode)
+	}
+	mailTo(t, app, "new@example.com")
+
+	//throttled, verified and unknown addresses all answer like the first send
+	for _, email := range []string{"new@example.com", "verified@example.com", "nobody@example.com"} {
+		resp := resend(email)
+		if resp.StatusCode != first.StatusCode || resp.Header.Get("Retry-After") != "" {
+			t.Errorf("resend to %s: status %d, want %d like any other", email, resp.StatusCode, first.StatusCode)
+		}
+	}
+	noMail(t, app)
+}
+
+func TestUpdateMeSendsLinkForEveryNewEmail(t *testing.T) {
+	app, db := newTestApp(t)
+	user := db.addUser("ann@example.com", "correct horse")
+	token, _ := app.signIn(t, "ann@example.com", "correct horse")
+
+	for _, email := range []string{"ann@example.org", "ann@example.net"} {
+		w := app.do(t, testRequest{method: "PATCH", path: "/me", token: token, body: `{"email":"` + email + `"}`})
+		if w.Code != http.StatusOK {
+			t.Fatalf("changing email to %s: status %d: %s", email, w.Code, w.Body)
+		}
+		mailTo(t, app, email)
+	}
+
+	//the resend throttle still applies to the new address
+	db.verificationSent[int(user.ID)] = time.Now()
+	w := app.do(t, testRequest{method: "POST", path: "/verify/resend", body: `{"email":"ann@example.net"}`})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("resend: status %d", w.Code)
+	}
+	noMail(t, app)
+}
@@ -0,0 +1,144 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kind classifies an error so the HTTP layer can pick a status code for it.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindConflict
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindTooManyRequests
+	KindBadRequest
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not_found"
+	case KindConflict:
+		return "conflict"
+	case KindValidation:
+		return "validation"
+	case KindUnauthorized:
+		return "unauthorized"
+	case KindForbidden:
+		return "forbidden"
+	case KindTooManyRequests:
+		return "too_many_requests"
+	case KindBadRequest:
+		return "bad_request"
+	default:
+		return "internal"
+	}
+}
+
+// FieldError describes a problem with a single field of a request payload.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a domain error. Message, Code and Fields are safe to show to
+// clients; Err is the underlying cause and is only meant for logs.
+type Error struct {
+	Kind    Kind
+	Message string
+	// Code is a machine-readable reason that is more specific than Kind.
+	Code   string
+	Fields []FieldError
+	// RetryAfter tells clients of rate limited requests when to try again.
+	RetryAfter time.Duration
+	Err        error
+}
+
+// ErrorCode returns Code, falling back to the name of the error's Kind.
+func (e *Error) ErrorCode() string {
+	if e.Code != "" {
+		return e.Code
+	}
+	return e.Kind.String()
+}
+
+// WithCode returns a copy of e with a more specific machine-readable code.
+func (e *Error) WithCode(code string) *Error {
+	c := *e
+	c.Code = code
+	return &c
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+func Wrap(kind Kind, message string, err error) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func NotFound(message string) *Error {
+	return New(KindNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return New(KindConflict, message)
+}
+
+func Validation(message string) *Error {
+	return New(KindValidation, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(KindUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(KindForbidden, message)
+}
+
+func TooManyRequests(message string, retryAfter time.Duration) *Error {
+	return &Error{Kind: KindTooManyRequests, Message: message, RetryAfter: retryAfter}
+}
+
+// BadRequest reports a request that could not be read, such as malformed JSON
+// or a non-numeric id, as opposed to one that was read but failed validation.
+func BadRequest(message string) *Error {
+	return New(KindBadRequest, message)
+}
+
+func Internal(err error) *Error {
+	return Wrap(KindInternal, "internal server error", err)
+}
+
+// As returns the first *Error in err's chain, if any.
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// Is reports whether err carries a domain error of the given kind.
+func Is(err error, kind Kind) bool {
+	e, ok := As(err)
+	return ok && e.Kind == kind
+}
@@ -0,0 +1,141 @@
+// Package lockout slows down password guessing by locking a key, such as an
+// account or a source IP, for exponentially longer after repeated failures.
+package lockout
+
+import (
+	"context"
+	"go-restapi/inernal/models"
+	"sync"
+	"time"
+)
+
+// Store keeps failed attempt counts. MemoryStore suits a single instance;
+// the Postgres repository implements it too so that instances share counts.
+type Store interface {
+	// LoginAttempts returns the failures recorded for key, a zero count when
+	// there are none.
+	LoginAttempts(ctx context.Context, key string) (*models.LoginAttempt, error)
+	// RecordLoginFailure adds a failure for key, starting the count over when
+	// the previous failure is older than window.
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*models.LoginAttempt, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// Policy decides how long a key is locked. After Threshold failures the key
+// is locked for BaseDelay, doubling with every further failure up to
+// MaxDelay. Failures are forgotten once none happened for Window.
+type Policy struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+}
+
+// Delay returns how long after its last failure an attempt is locked.
+func (p Policy) Delay(a *models.LoginAttempt) time.Duration {
+	if p.Threshold <= 0 || a.Failures < p.Threshold {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.Threshold; i < a.Failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+type Limiter struct {
+	Store  Store
+	Policy Policy
+	// Prefix namespaces the keys of this limiter in a shared store.
+	Prefix string
+}
+
+// Check returns how much longer key stays locked, zero when it may try.
+func (l *Limiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	a, err := l.Store.LoginAttempts(ctx, l.Prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	return l.remaining(a, time.Now()), nil
+}
+
+// Fail records a failed attempt for key and returns how long it is now locked.
+func (l *Limiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	a, err := l.Store.RecordLoginFailure(ctx, l.Prefix+key, l.Policy.Window)
+	if err != nil {
+		return 0, err
+	}
+	return l.remaining(a, time.Now()), nil
+}
+
+// Reset forgets the failures for key, after a successful sign-in or when an
+// administrator unlocks it.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.Store.ResetLoginAttempts(ctx, l.Prefix+key)
+}
+
+func (l *Limiter) remaining(a *models.LoginAttempt, now time.Time) time.Duration {
+	if a.Failures == 0 || now.Sub(a.LastFailureAt) > l.Policy.Window {
+		return 0
+	}
+	remaining := a.LastFailureAt.Add(l.Policy.Delay(a)).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// MemoryStore keeps attempts in process memory.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempt
+	writes   int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]models.LoginAttempt)}
+}
+
+func (s *MemoryStore) LoginAttempts(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attempts[key]
+	if !ok {
+		a = models.LoginAttempt{Key: key}
+	}
+	return &a, nil
+}
+
+func (s *MemoryStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	a, ok := s.attempts[key]
+	if !ok || now.Sub(a.LastFailureAt) > window {
+		a = models.LoginAttempt{Key: key}
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	s.attempts[key] = a
+
+	//drop stale keys now and then so the map does not grow without bound
+	s.writes++
+	if s.writes%1000 == 0 {
+		for k, v := range s.attempts {
+			if now.Sub(v.LastFailureAt) > window {
+				delete(s.attempts, k)
+			}
+		}
+	}
+	return &a, nil
+}
+
+func (s *MemoryStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
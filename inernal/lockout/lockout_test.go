@@ -0,0 +1,114 @@
+package lockout
+
+import (
+	"context"
+	"go-restapi/inernal/models"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	Threshold: 3,
+	BaseDelay: time.Minute,
+	MaxDelay:  10 * time.Minute,
+	Window:    time.Hour,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		//capped at MaxDelay
+		{7, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		got := testPolicy.Delay(&models.LoginAttempt{Failures: tt.failures})
+		if got != tt.want {
+			t.Errorf("Delay after %d failures = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	disabled := testPolicy
+	disabled.Threshold = 0
+	if got := disabled.Delay(&models.LoginAttempt{Failures: 50}); got != 0 {
+		t.Errorf("Delay with no threshold = %v, want 0", got)
+	}
+}
+
+func TestLimiterRemaining(t *testing.T) {
+	l := &Limiter{Policy: testPolicy}
+	now := time.Now()
+	tests := []struct {
+		name     string
+		failures int
+		ago      time.Duration
+		want     time.Duration
+	}{
+		{"no failures", 0, 0, 0},
+		{"below threshold", 2, 0, 0},
+		{"just locked", 3, 0, time.Minute},
+		{"part of the delay passed", 4, 30 * time.Second, 90 * time.Second},
+		{"delay over", 4, 3 * time.Minute, 0},
+		{"capped", 20, 0, 10 * time.Minute},
+		{"outside the window", 20, 2 * time.Hour, 0},
+	}
+	for _, tt := range tests {
+		a := &models.LoginAttempt{Failures: tt.failures, LastFailureAt: now.Add(-tt.ago)}
+		if got := l.remaining(a, now); got != tt.want {
+			t.Errorf("%s: remaining = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterWithMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	l := &Limiter{Store: NewMemoryStore(), Policy: testPolicy, Prefix: "account:"}
+
+	for i := 1; i <= 4; i++ {
+		wait, err := l.Fail(ctx, "ann@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		locked := i >= testPolicy.Threshold
+		if (wait > 0) != locked {
+			t.Errorf("failure %d: wait %v, locked = %v", i, wait, locked)
+		}
+	}
+	wait, err := l.Check(ctx, "ann@example.com")
+	if err != nil || wait <= time.Minute || wait > 2*time.Minute {
+		t.Errorf("Check after 4 failures = %v, %v, want up to 2m", wait, err)
+	}
+	if wait, _ := l.Check(ctx, "bob@example.com"); wait != 0 {
+		t.Errorf("other key locked for %v", wait)
+	}
+
+	err = l.Reset(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := l.Check(ctx, "ann@example.com"); wait != 0 {
+		t.Errorf("locked for %v after reset", wait)
+	}
+}
+
+func TestMemoryStoreForgetsOldFailures(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	s.attempts["k"] = models.LoginAttempt{Key: "k", Failures: 9, LastFailureAt: time.Now().Add(-2 * time.Hour)}
+
+	a, err := s.RecordLoginFailure(ctx, "k", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Failures != 1 {
+		t.Errorf("failures after the window = %d, want the count to start over", a.Failures)
+	}
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"go-restapi/inernal/validator"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix starts every API key so leaked keys are easy to recognise.
+const APIKeyPrefix = "mvk_"
+
+// APIKey lets a service call the API as UserID, limited to Scopes. Only the
+// hash of the key is stored; Prefix is kept so people can tell keys apart.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// GenerateAPIKey returns a new key to hand out once, its hash and the
+// prefix stored alongside it.
+func GenerateAPIKey() (key string, hash string, prefix string, err error) {
+	plainText, _, err := GenerateToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + plainText
+	return key, HashToken(key), key[:12], nil
+}
+
+// Active reports whether the key may still be used at t.
+func (k *APIKey) Active(t time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(t))
+}
+
+func (k *APIKey) Check(v *validator.Validator) {
+	v.Check(strings.TrimSpace(k.Name) != "", "name", "must be provided")
+	v.Check(len(k.Name) <= 255, "name", "must not be more than 255 characters long")
+	v.Check(len(k.Scopes) > 0, "scopes", "must contain at least one permission")
+	v.Check(validator.Unique(k.Scopes), "scopes", "must not contain duplicate values")
+	if k.ExpiresAt != nil {
+		v.Check(k.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+func (k *APIKey) Validate() error {
+	v := validator.New()
+	k.Check(v)
+	return v.Err()
+}
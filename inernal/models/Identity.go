@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Identity links an account at an external OpenID Connect provider, named
+// by Provider and Subject, to a user.
+type Identity struct {
+	Provider    string
+	Subject     string
+	UserID      int
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt *time.Time
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Impersonation is the audit record of an admin, the actor, being issued a
+// token to act as another user, the subject. The record outlives both
+// accounts: ActorID and SubjectID become nil when the user is deleted, and
+// the emails they had at the time remain.
+type Impersonation struct {
+	ID           int       `json:"id"`
+	ActorID      *int      `json:"actor_id"`
+	ActorEmail   string    `json:"actor_email"`
+	SubjectID    *int      `json:"subject_id"`
+	SubjectEmail string    `json:"subject_email"`
+	TokenID      string    `json:"token_id"`
+	Reason       string    `json:"reason"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"user_agent"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
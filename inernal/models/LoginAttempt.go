@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// LoginAttempt counts the recent failed sign-ins for a key, such as an
+// account or a source IP.
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+}
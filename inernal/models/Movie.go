@@ -1,6 +1,14 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"go-restapi/inernal/validator"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var MPAARatings = []string{"G", "PG", "PG-13", "R", "NC-17"}
 
 type Movie struct {
 	ID          int       `json:"id"`
@@ -12,10 +20,29 @@ type Movie struct {
 	Image       string    `json:"image"`
 	CreatedAt   time.Time `json:"-"`
 	UpdatedAt   time.Time `json:"-"`
+	CreatedBy   int       `json:"-"`
+	UpdatedBy   int       `json:"-"`
 	Genres      []*Genre  `json:"genres,omitempty"`
 	GenresArray []int     `json:"genres_array,omitempty"`
 }
 
+// Check records every problem with the movie's fields in v.
+func (m *Movie) Check(v *validator.Validator) {
+	v.Check(strings.TrimSpace(m.Title) != "", "title", "must be provided")
+	v.Check(utf8.RuneCountInString(m.Title) <= 512, "title", "must not be more than 512 characters long")
+	v.Check(!m.ReleaseDate.IsZero(), "release_date", "must be provided")
+	v.Check(m.RunTime > 0, "runtime", "must be a positive number of minutes")
+	v.Check(validator.In(m.MPAARating, MPAARatings...), "mpaa_rating",
+		fmt.Sprintf("must be one of %s", strings.Join(MPAARatings, ", ")))
+	v.Check(validator.Unique(m.GenresArray), "genres_array", "must not contain duplicates")
+}
+
+func (m *Movie) Validate() error {
+	v := validator.New()
+	m.Check(v)
+	return v.Err()
+}
+
 type Genre struct {
 	ID        int       `json:"id"`
 	Genre     string    `json:"genre"`
@@ -23,3 +50,21 @@ type Genre struct {
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
+
+type MovieSearchResult struct {
+	Movie
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// Check records every problem with the genre's fields in v.
+func (g *Genre) Check(v *validator.Validator) {
+	v.Check(strings.TrimSpace(g.Genre) != "", "genre", "must be provided")
+	v.Check(utf8.RuneCountInString(g.Genre) <= 255, "genre", "must not be more than 255 characters long")
+}
+
+func (g *Genre) Validate() error {
+	v := validator.New()
+	g.Check(v)
+	return v.Err()
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// PasswordReset is an outstanding password reset request. Only the hash of
+// the token mailed to the user is stored.
+type PasswordReset struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
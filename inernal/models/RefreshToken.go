@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// RefreshToken is the server-side record of an issued refresh token. Tokens
+// issued from the same login share a FamilyID, and each rotation points the
+// used token at its replacement.
+type RefreshToken struct {
+	ID         string     `json:"-"`
+	UserID     int        `json:"-"`
+	FamilyID   string     `json:"-"`
+	ExpiresAt  time.Time  `json:"-"`
+	UsedAt     *time.Time `json:"-"`
+	ReplacedBy string     `json:"-"`
+	RevokedAt  *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"-"`
+}
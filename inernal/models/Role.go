@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+const (
+	PermissionMoviesRead       = "movies:read"
+	PermissionMoviesWrite      = "movies:write"
+	PermissionGenresWrite      = "genres:write"
+	PermissionUsersWrite       = "users:write"
+	PermissionUsersImpersonate = "users:impersonate"
+	PermissionTokensManage     = "tokens:manage"
+)
+
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+}
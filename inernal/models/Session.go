@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Session is one sign-in of a user on a device. Its ID is the family id
+// shared by the refresh tokens issued for that sign-in, and it stays active
+// while the family holds an unused, unrevoked, unexpired token.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// Current marks the session the request was made from.
+	Current bool `json:"current"`
+}
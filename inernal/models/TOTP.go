@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// TOTP is a user's authenticator app enrollment. It only counts as a second
+// factor once EnabledAt is set, after the user confirmed a first code.
+type TOTP struct {
+	UserID    int
+	Secret    string
+	EnabledAt *time.Time
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+func (t *TOTP) Enabled() bool {
+	return t != nil && t.EnabledAt != nil
+}
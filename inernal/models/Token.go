@@ -0,0 +1,25 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateToken returns a random single-use token to hand to a user and the
+// hash that is stored in its place.
+func GenerateToken() (plainText string, hash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	plainText = base64.RawURLEncoding.EncodeToString(b)
+	return plainText, HashToken(plainText), nil
+}
+
+func HashToken(plainText string) string {
+	sum := sha256.Sum256([]byte(plainText))
+	return hex.EncodeToString(sum[:])
+}
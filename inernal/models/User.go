@@ -1,30 +1,67 @@
 package models
 
 import (
-	"errors"
-	"golang.org/x/crypto/bcrypt"
+	"go-restapi/inernal/password"
+	"go-restapi/inernal/validator"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+const MinPasswordLength = 8
+
 type User struct {
-	ID        int64     `json:"id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"-"`
-	UpdateAt  time.Time `json:"-"`
+	ID         int64      `json:"id"`
+	FirstName  string     `json:"first_name"`
+	LastName   string     `json:"last_name"`
+	Email      string     `json:"email"`
+	Password   string     `json:"-"`
+	Roles      []string   `json:"roles,omitempty"`
+	CreatedAt  time.Time  `json:"-"`
+	UpdateAt   time.Time  `json:"-"`
+	VerifiedAt *time.Time `json:"verified_at"`
+	// TOTPEnabled reports whether the user signs in with a second factor.
+	TOTPEnabled bool `json:"totp_enabled"`
+}
+
+func (u *User) Verified() bool {
+	return u.VerifiedAt != nil
 }
 
-func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
+func (u *User) PasswordMatches(hashers *password.Hashers, plainText string) (bool, error) {
+	match, _, err := u.VerifyPassword(hashers, plainText)
+	return match, err
+}
+
+// VerifyPassword checks plainText against the stored hash. rehash is true
+// for a match whose hash was made with an older algorithm or parameters.
+func (u *User) VerifyPassword(hashers *password.Hashers, plainText string) (match bool, rehash bool, err error) {
+	return hashers.Verify(u.Password, plainText)
+}
+
+// SetPassword replaces the stored hash with one of plainText made by the
+// preferred hasher.
+func (u *User) SetPassword(hashers *password.Hashers, plainText string) error {
+	hash, err := hashers.Hash(plainText)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return err
 	}
-	return true, nil
+	u.Password = hash
+	return nil
+}
+
+// NormalizeEmail returns the canonical form emails are stored and looked up in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check records every problem with the user's profile fields in v.
+func (u *User) Check(v *validator.Validator) {
+	v.Check(strings.TrimSpace(u.FirstName) != "", "first_name", "must be provided")
+	v.Check(utf8.RuneCountInString(u.FirstName) <= 255, "first_name", "must not be more than 255 characters long")
+	v.Check(strings.TrimSpace(u.LastName) != "", "last_name", "must be provided")
+	v.Check(utf8.RuneCountInString(u.LastName) <= 255, "last_name", "must not be more than 255 characters long")
+	v.Check(u.Email != "", "email", "must be provided")
+	v.Check(len(u.Email) <= 255, "email", "must not be more than 255 characters long")
+	v.Check(validator.Matches(u.Email, validator.EmailRX), "email", "must be a valid email address")
 }
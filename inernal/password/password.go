@@ -0,0 +1,171 @@
+// Package password hashes and verifies user passwords. Hashes name their
+// algorithm and parameters, so stored hashes keep verifying after the
+// preferred algorithm or its cost changes, and can be upgraded on sign-in.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// BcryptMaxLength is the longest password, in bytes, bcrypt hashes in full;
+// anything past it is ignored.
+const BcryptMaxLength = 72
+
+var ErrUnknownHash = errors.New("password hash format not recognized")
+
+// Hasher is one password hashing algorithm with its current parameters.
+type Hasher interface {
+	Hash(plainText string) (string, error)
+	Verify(hash, plainText string) (bool, error)
+	// Identifies reports whether hash was made by this algorithm.
+	Identifies(hash string) bool
+	// NeedsRehash reports whether hash was made with other parameters than
+	// the hasher's current ones.
+	NeedsRehash(hash string) bool
+}
+
+// Hashers hashes new passwords with Preferred and verifies hashes made by
+// Preferred or any of Accepted.
+type Hashers struct {
+	Preferred Hasher
+	Accepted  []Hasher
+}
+
+func (h *Hashers) Hash(plainText string) (string, error) {
+	return h.Preferred.Hash(plainText)
+}
+
+// Verify checks plainText against hash with the algorithm that made it.
+// rehash is true for a match whose hash should be replaced by one from
+// Preferred.
+func (h *Hashers) Verify(hash, plainText string) (match bool, rehash bool, err error) {
+	for _, hasher := range append([]Hasher{h.Preferred}, h.Accepted...) {
+		if !hasher.Identifies(hash) {
+			continue
+		}
+		match, err = hasher.Verify(hash, plainText)
+		if err != nil || !match {
+			return false, false, err
+		}
+		return true, hasher != h.Preferred || hasher.NeedsRehash(hash), nil
+	}
+	return false, false, ErrUnknownHash
+}
+
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(plainText string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plainText), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b Bcrypt) Verify(hash, plainText string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plainText))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (b Bcrypt) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (b Bcrypt) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
+
+// Argon2id hashes into the PHC string format used by other argon2
+// implementations: $argon2id$v=19$m=65536,t=3,p=2$salt$key
+type Argon2id struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2Params struct {
+	version     int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+var b64 = base64.RawStdEncoding
+
+func (a Argon2id) Hash(plainText string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(plainText), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(hash, plainText string) (bool, error) {
+	p, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(plainText), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (a Argon2id) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (a Argon2id) NeedsRehash(hash string) bool {
+	p, err := parseArgon2id(hash)
+	return err != nil || p.version != argon2.Version || p.memory != a.Memory || p.iterations != a.Iterations ||
+		p.parallelism != a.Parallelism || uint32(len(p.salt)) != a.SaltLength || uint32(len(p.key)) != a.KeyLength
+}
+
+func parseArgon2id(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHash
+	}
+	var p argon2Params
+	_, err := fmt.Sscanf(parts[2], "v=%d", &p.version)
+	if err != nil {
+		return nil, ErrUnknownHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism)
+	if err != nil {
+		return nil, ErrUnknownHash
+	}
+	p.salt, err = b64.DecodeString(parts[4])
+	if err != nil {
+		return nil, ErrUnknownHash
+	}
+	p.key, err = b64.DecodeString(parts[5])
+	if err != nil || len(p.key) == 0 {
+		return nil, ErrUnknownHash
+	}
+	return &p, nil
+}
@@ -0,0 +1,131 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// cheap keeps argon2id fast enough for tests.
+var cheap = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	hash, err := cheap.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") || !cheap.Identifies(hash) {
+		t.Fatalf("unexpected hash %s", hash)
+	}
+
+	p, err := parseArgon2id(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.memory != 64 || p.iterations != 1 || p.parallelism != 1 || len(p.salt) != 16 || len(p.key) != 32 {
+		t.Errorf("parsed parameters %+v", p)
+	}
+
+	for plain, want := range map[string]bool{"correct horse": true, "correct horse ": false, "": false} {
+		match, err := cheap.Verify(hash, plain)
+		if err != nil || match != want {
+			t.Errorf("Verify(%q) = %v, %v, want %v", plain, match, err, want)
+		}
+	}
+
+	other, _ := cheap.Hash("correct horse")
+	if other == hash {
+		t.Error("two hashes of the same password share a salt")
+	}
+}
+
+func TestParseArgon2idRejectsMalformedHashes(t *testing.T) {
+	hash, _ := cheap.Hash("correct horse")
+	parts := strings.Split(hash, "$")
+	for _, bad := range []string{
+		"",
+		"$argon2i$" + strings.Join(parts[2:], "$"),
+		strings.Join(parts[:5], "$"),
+		strings.Replace(hash, "v=19", "v=x", 1),
+		strings.Replace(hash, "m=64,t=1,p=1", "m=64", 1),
+		strings.Join(append(parts[:4:4], "!!", parts[5]), "$"),
+		strings.Join(append(parts[:5:5], ""), "$"),
+	} {
+		if _, err := parseArgon2id(bad); !errors.Is(err, ErrUnknownHash) {
+			t.Errorf("parseArgon2id(%q) = %v, want ErrUnknownHash", bad, err)
+		}
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	hash, _ := cheap.Hash("correct horse")
+	if cheap.NeedsRehash(hash) {
+		t.Error("hash made with the current parameters needs rehashing")
+	}
+	for name, stronger := range map[string]Argon2id{
+		"memory":      {Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		"iterations":  {Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		"parallelism": {Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32},
+		"salt":        {Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 32, KeyLength: 32},
+		"key":         {Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64},
+	} {
+		if !stronger.NeedsRehash(hash) {
+			t.Errorf("weaker %s does not need rehashing", name)
+		}
+	}
+}
+
+func TestBcryptNeedsRehash(t *testing.T) {
+	b := Bcrypt{Cost: 5}
+	hash, err := Bcrypt{Cost: 4}.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.NeedsRehash(hash) {
+		t.Error("cheaper bcrypt hash does not need rehashing")
+	}
+	if (Bcrypt{Cost: 4}).NeedsRehash(hash) {
+		t.Error("hash made with the current cost needs rehashing")
+	}
+	if !b.NeedsRehash("not a hash") {
+		t.Error("unparseable hash does not need rehashing")
+	}
+}
+
+func TestHashersVerify(t *testing.T) {
+	bcrypt := Bcrypt{Cost: 4}
+	hashers := &Hashers{Preferred: cheap, Accepted: []Hasher{bcrypt}}
+	legacy, err := bcrypt.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := hashers.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, _ := Argon2id{Memory: 32, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}.Hash("correct horse")
+
+	tests := []struct {
+		name   string
+		hash   string
+		plain  string
+		match  bool
+		rehash bool
+	}{
+		{"current", current, "correct horse", true, false},
+		{"bcrypt still verifies", legacy, "correct horse", true, true},
+		{"weaker argon2id", stale, "correct horse", true, true},
+		{"wrong password", legacy, "battery staple", false, false},
+	}
+	for _, tt := range tests {
+		match, rehash, err := hashers.Verify(tt.hash, tt.plain)
+		if err != nil || match != tt.match || rehash != tt.rehash {
+			t.Errorf("%s: Verify = %v, %v, %v, want %v, %v", tt.name, match, rehash, err, tt.match, tt.rehash)
+		}
+	}
+
+	_, _, err = hashers.Verify("$1$md5crypt$hash", "correct horse")
+	if !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("unknown format: err = %v, want ErrUnknownHash", err)
+	}
+}
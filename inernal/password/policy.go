@@ -0,0 +1,72 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-restapi/inernal/validator"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policy decides which new passwords are acceptable.
+type Policy struct {
+	MinLength int
+	// MaxLength is in bytes, zero for no limit. It is needed when bcrypt
+	// hashes new passwords, as bcrypt ignores anything past BcryptMaxLength.
+	MaxLength int
+	// Breached holds known compromised passwords, lowercased.
+	Breached map[string]bool
+	// History is how many of the user's most recent passwords, the current
+	// one included, may not be chosen again.
+	History int
+}
+
+// Check records problems with plainText in v. Reuse is checked separately
+// with Reused since it needs the user's previous hashes.
+func (p *Policy) Check(v *validator.Validator, field, plainText string) {
+	v.Check(utf8.RuneCountInString(plainText) >= p.MinLength, field, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	if p.MaxLength > 0 {
+		v.Check(len(plainText) <= p.MaxLength, field, fmt.Sprintf("must not be more than %d bytes long", p.MaxLength))
+	}
+	v.Check(!p.Breached[strings.ToLower(plainText)], field, "is too common or has appeared in a data breach")
+}
+
+// Reused reports whether plainText matches any of the previous hashes.
+func (p *Policy) Reused(hashers *Hashers, plainText string, previous []string) (bool, error) {
+	for _, hash := range previous {
+		match, _, err := hashers.Verify(hash, plainText)
+		if err != nil && !errors.Is(err, ErrUnknownHash) {
+			return false, err
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// LoadBreached reads a list of compromised passwords, one per line, such as
+// a common-passwords list. Blank lines and lines starting with # are skipped.
+func LoadBreached(file string) (map[string]bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	breached := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return breached, nil
+}
@@ -0,0 +1,91 @@
+package password
+
+import (
+	"errors"
+	"go-restapi/inernal/validator"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := Policy{
+		MinLength: 8,
+		MaxLength: BcryptMaxLength,
+		Breached:  map[string]bool{"password123": true},
+	}
+	tests := []struct {
+		plain string
+		ok    bool
+	}{
+		{"correct horse", true},
+		{"short", false},
+		{"çççççççç", true},
+		{strings.Repeat("a", BcryptMaxLength), true},
+		{strings.Repeat("a", BcryptMaxLength+1), false},
+		{"Password123", false},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		policy.Check(v, "password", tt.plain)
+		if ok := v.Err() == nil; ok != tt.ok {
+			t.Errorf("Check(%q) ok = %v, want %v", tt.plain, ok, tt.ok)
+		}
+	}
+
+	//without a maximum, long passwords are fine
+	policy.MaxLength = 0
+	v := validator.New()
+	policy.Check(v, "password", strings.Repeat("a", 1000))
+	if err := v.Err(); err != nil {
+		t.Errorf("long password refused without a maximum: %v", err)
+	}
+}
+
+func TestPolicyReused(t *testing.T) {
+	hashers := &Hashers{Preferred: cheap, Accepted: []Hasher{Bcrypt{Cost: 4}}}
+	var previous []string
+	for _, plain := range []string{"first password", "second password"} {
+		hash, err := hashers.Hash(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		previous = append(previous, hash)
+	}
+	legacy, _ := Bcrypt{Cost: 4}.Hash("bcrypt password")
+	previous = append(previous, legacy, "unrecognized hash")
+
+	policy := Policy{History: 3}
+	for plain, want := range map[string]bool{
+		"first password":  true,
+		"second password": true,
+		"bcrypt password": true,
+		"new password":    false,
+	} {
+		reused, err := policy.Reused(hashers, plain, previous)
+		if err != nil || reused != want {
+			t.Errorf("Reused(%q) = %v, %v, want %v", plain, reused, err, want)
+		}
+	}
+}
+
+func TestLoadBreached(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(file, []byte("# common passwords\nPassword123\r\n\nqwerty\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breached, err := LoadBreached(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breached) != 2 || !breached["password123"] || !breached["qwerty"] {
+		t.Errorf("LoadBreached = %v", breached)
+	}
+
+	_, err = LoadBreached(filepath.Join(t.TempDir(), "missing.txt"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"go-restapi/inernal/models"
+	"os"
+	"testing"
+	"time"
+)
+
+// testRepo connects to the database named by TEST_DATABASE_DSN, which must
+// have sql/create_tables.sql loaded. Tests that need it are skipped without it.
+func testRepo(t *testing.T) *PostgresDBRepo {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &PostgresDBRepo{Db: db, Timeout: 5 * time.Second}
+}
+
+// testUser inserts a throwaway user and removes it, with everything that
+// references it, when the test ends.
+func testUser(t *testing.T, m *PostgresDBRepo) int {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+	id, err := m.InsertUser(ctx, models.User{
+		FirstName: "Test",
+		LastName:  "User",
+		Email:     fmt.Sprintf("test-%d@example.com", now.UnixNano()),
+		Password:  "x",
+		CreatedAt: now,
+		UpdateAt:  now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = m.Db.Exec(`delete from users where id = $1`, id)
+	})
+	return id
+}
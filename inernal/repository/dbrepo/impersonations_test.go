@@ -0,0 +1,63 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"go-restapi/inernal/models"
+	"testing"
+	"time"
+)
+
+func TestImpersonationOutlivesUsers(t *testing.T) {
+	m := testRepo(t)
+	ctx := context.Background()
+	actorID := testUser(t, m)
+	subjectID := testUser(t, m)
+	subject, err := m.GetUserById(ctx, subjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenID := fmt.Sprintf("impersonation-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		_, _ = m.Db.Exec(`delete from impersonations where token_id = $1`, tokenID)
+	})
+	now := time.Now()
+	err = m.InsertImpersonation(ctx, models.Impersonation{
+		ActorID:   &actorID,
+		SubjectID: &subjectID,
+		TokenID:   tokenID,
+		Reason:    "support ticket",
+		IP:        "127.0.0.1",
+		ExpiresAt: now.Add(time.Hour),
+		CreatedAt: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Db.Exec(`delete from users where id = $1`, actorID)
+	if err != nil {
+		t.Fatalf("deleting the actor: %v", err)
+	}
+	var (
+		storedActor *int
+		actorEmail  string
+	)
+	err = m.Db.QueryRow(`select actor_id, actor_email from impersonations where token_id = $1`, tokenID).
+		Scan(&storedActor, &actorEmail)
+	if err != nil {
+		t.Fatalf("record gone with the actor: %v", err)
+	}
+	if storedActor != nil || actorEmail == "" {
+		t.Errorf("actor_id = %v, actor_email = %q, want nil and the old email", storedActor, actorEmail)
+	}
+
+	records, err := m.UserImpersonations(ctx, subjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].SubjectEmail != subject.Email || records[0].ActorEmail != actorEmail {
+		t.Errorf("records = %+v", records)
+	}
+}
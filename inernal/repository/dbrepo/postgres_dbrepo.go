@@ -3,22 +3,139 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"go-restapi/inernal/apperror"
 	"go-restapi/inernal/models"
+	"go-restapi/inernal/repository"
+	"strings"
 	"time"
 )
 
 type PostgresDBRepo struct {
 	Db *sql.DB
+	// Timeout bounds every operation that has no entry in Timeouts.
+	Timeout time.Duration
+	// Timeouts holds per-operation overrides keyed by method name, e.g. "SearchMovies".
+	Timeouts map[string]time.Duration
+	tx       *sql.Tx
 }
 
 const dbTimeout = time.Second * 3
 
+// Postgres SQLSTATE codes for constraint violations.
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+// executor is the part of *sql.DB and *sql.Tx the repository queries through.
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 func (m *PostgresDBRepo) Connection() *sql.DB {
 	return m.Db
 }
 
-func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// withTimeout derives the context for a single operation from the caller's
+// context, bounded by the timeout configured for op.
+func (m *PostgresDBRepo) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout := m.Timeout
+	if t, ok := m.Timeouts[op]; ok {
+		timeout = t
+	}
+	if timeout <= 0 {
+		timeout = dbTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// dbError translates driver errors into the errors handlers understand: a
+// cancelled or expired context becomes repository.ErrCanceled or
+// repository.ErrTimeout, missing rows and constraint violations become domain
+// errors, and anything else is reported as an internal error.
+func dbError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, repository.ErrCanceled) || errors.Is(err, repository.ErrTimeout) {
+		return err
+	}
+	if _, ok := apperror.As(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %v", repository.ErrCanceled, err)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %v", repository.ErrTimeout, err)
+	case errors.Is(err, sql.ErrNoRows):
+		return apperror.Wrap(apperror.KindNotFound, "record not found", err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return apperror.Wrap(apperror.KindConflict, "record already exists", err)
+		case pgForeignKeyViolation:
+			return apperror.Wrap(apperror.KindConflict, "record references or is referenced by another record", err)
+		}
+	}
+	return apperror.Internal(err)
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// executor returns the open transaction when the repo is bound to one.
+func (m *PostgresDBRepo) executor() executor {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.Db
+}
+
+// WithTx runs fn against a repo bound to a single transaction, committing when
+// fn succeeds and rolling back otherwise. Calls on a repo that is already in a
+// transaction join it instead of starting a new one.
+func (m *PostgresDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		return fn(tx)
+	})
+}
+
+func (m *PostgresDBRepo) inTx(ctx context.Context, fn func(tx *PostgresDBRepo) error) (err error) {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	tx, err := m.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	err = fn(&PostgresDBRepo{Db: m.Db, Timeout: m.Timeout, Timeouts: m.Timeouts, tx: tx})
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	return dbError(ctx, tx.Commit())
+}
+
+func (m *PostgresDBRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	ctx, cancel := m.withTimeout(ctx, "OneMovie")
 	defer cancel()
 	query := `
 		select 
@@ -28,7 +145,7 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 		    movies
 		where id=$1
 	`
-	row := m.Db.QueryRowContext(ctx, query, id)
+	row := m.executor().QueryRowContext(ctx, query, id)
 	var movie models.Movie
 	err := row.Scan(
 		&movie.ID,
@@ -41,8 +158,11 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 		&movie.CreatedAt,
 		&movie.UpdatedAt,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repository.ErrMovieNotFound
+	}
 	if err != nil {
-		return nil, err
+		return nil, dbError(ctx, err)
 	}
 
 	query = `
@@ -52,9 +172,9 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 		where mg.movie_id = $1
 		order by g.genre
 	`
-	rows, err := m.Db.QueryContext(ctx, query, id)
+	rows, err := m.executor().QueryContext(ctx, query, id)
 	if err != nil && err != sql.ErrNoRows {
-		return nil, err
+		return nil, dbError(ctx, err)
 	}
 	defer rows.Close()
 	var genres []*models.Genre
@@ -65,7 +185,7 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 			&g.Genre,
 		)
 		if err != nil {
-			return nil, err
+			return nil, dbError(ctx, err)
 		}
 		genres = append(genres, &g)
 	}
@@ -73,8 +193,8 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 	return &movie, nil
 }
 
-func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) OneMovieForEdit(ctx context.Context, id int) (*models.Movie, []*models.Genre, error) {
+	ctx, cancel := m.withTimeout(ctx, "OneMovieForEdit")
 	defer cancel()
 	query := `
 		select 
@@ -84,7 +204,7 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 		    movies
 		where id=$1
 	`
-	row := m.Db.QueryRowContext(ctx, query, id)
+	row := m.executor().QueryRowContext(ctx, query, id)
 	var movie models.Movie
 	err := row.Scan(
 		&movie.ID,
@@ -97,8 +217,11 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 		&movie.CreatedAt,
 		&movie.UpdatedAt,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, repository.ErrMovieNotFound
+	}
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, dbError(ctx, err)
 	}
 
 	query = `
@@ -108,9 +231,9 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 		where mg.movie_id = $1
 		order by g.genre
 	`
-	rows, err := m.Db.QueryContext(ctx, query, id)
+	rows, err := m.executor().QueryContext(ctx, query, id)
 	if err != nil && err != sql.ErrNoRows {
-		return nil, nil, err
+		return nil, nil, dbError(ctx, err)
 	}
 	defer rows.Close()
 	var genres []*models.Genre
@@ -122,7 +245,7 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 			&g.Genre,
 		)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, dbError(ctx, err)
 		}
 		genres = append(genres, &g)
 		genresArray = append(genresArray, g.ID)
@@ -131,7 +254,11 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 	movie.GenresArray = genresArray
 	var allGenres []*models.Genre
 	query = "select id, genre from genres order by genre"
-	grows, err := m.Db.QueryContext(ctx, query)
+	grows, err := m.executor().QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, dbError(ctx, err)
+	}
+	defer grows.Close()
 	for grows.Next() {
 		var g models.Genre
 		err := grows.Scan(
@@ -139,29 +266,31 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 			&g.Genre,
 		)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, dbError(ctx, err)
 		}
 		allGenres = append(allGenres, &g)
 	}
 	return &movie, allGenres, nil
 }
 
-func (m *PostgresDBRepo) AllMovies() ([]*models.Movie, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) AllMovies(ctx context.Context, q repository.MovieQuery) ([]*models.Movie, int, error) {
+	ctx, cancel := m.withTimeout(ctx, "AllMovies")
 	defer cancel()
-	query := `
-		select
-			id, title, mpaa_rating, release_date, runtime,
-			description, coalesce(image, ''), created_at,
-			updated_at
-		from
-			movies
-		order by 
-		    title
-	`
-	rows, err := m.Db.QueryContext(ctx, query)
+
+	where, args := movieFilters(q)
+
+	var total int
+	countQuery := `select count(*) from movies ` + where
+	err := m.executor().QueryRowContext(ctx, countQuery, args...).Scan(&total)
 	if err != nil {
-		return nil, err
+		return nil, 0, dbError(ctx, err)
+	}
+
+	query, args := movieListQuery(q, where, args)
+
+	rows, err := m.executor().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, dbError(ctx, err)
 	}
 	defer rows.Close()
 	var allmovies []*models.Movie
@@ -179,26 +308,92 @@ func (m *PostgresDBRepo) AllMovies() ([]*models.Movie, error) {
 			&movie.UpdatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, 0, dbError(ctx, err)
 		}
 		allmovies = append(allmovies, &movie)
 	}
-	return allmovies, nil
+	return allmovies, total, nil
+}
+
+// movieListQuery builds the paged, sorted select for AllMovies around the
+// where clause and arguments from movieFilters. Only columns listed in
+// repository.MovieSortFields are sorted by; anything else falls back to title.
+func movieListQuery(q repository.MovieQuery, where string, args []any) (string, []any) {
+	sortColumn := "title"
+	for _, f := range repository.MovieSortFields {
+		if q.Sort == f {
+			sortColumn = f
+		}
+	}
+	direction := "asc"
+	if q.Desc {
+		direction = "desc"
+	}
+
+	query := fmt.Sprintf(`
+		select
+			id, title, mpaa_rating, release_date, runtime,
+			description, coalesce(image, ''), created_at,
+			updated_at
+		from
+			movies
+		%s
+		order by 
+		    %s %s, id %s
+		limit $%d offset $%d
+	`, where, sortColumn, direction, direction, len(args)+1, len(args)+2)
+	return query, append(args, q.PageSize, q.Offset())
+}
+
+// movieFilters builds the where clause and its arguments for a MovieQuery.
+func movieFilters(q repository.MovieQuery) (string, []any) {
+	var conditions []string
+	var args []any
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if q.GenreID > 0 {
+		add("id in (select movie_id from movies_genres where genre_id = $%d)", q.GenreID)
+	}
+	if q.MPAARating != "" {
+		add("mpaa_rating = $%d", q.MPAARating)
+	}
+	if q.YearFrom > 0 {
+		add("extract(year from release_date) >= $%d", q.YearFrom)
+	}
+	if q.YearTo > 0 {
+		add("extract(year from release_date) <= $%d", q.YearTo)
+	}
+	if q.RuntimeMin > 0 {
+		add("runtime >= $%d", q.RuntimeMin)
+	}
+	if q.RuntimeMax > 0 {
+		add("runtime <= $%d", q.RuntimeMax)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "where " + strings.Join(conditions, " and "), args
 }
 
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := m.withTimeout(ctx, "GetUserByEmail")
 	defer cancel()
 
 	query := `
 		select 
-			id, email, first_name, last_name, password, created_at, updated_at
+			id, email, first_name, last_name, password, created_at, updated_at,
+			verified_at,
+			exists(select 1 from user_totp t where t.user_id = users.id and t.enabled_at is not null)
 		from
 		    users
-		where email=$1
+		where lower(email)=lower($1)
 	`
 	var user models.User
-	row := m.Db.QueryRowContext(ctx, query, email)
+	row := m.executor().QueryRowContext(ctx, query, email)
 	err := row.Scan(
 		&user.ID,
 		&user.Email,
@@ -207,28 +402,32 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
 		&user.Password,
 		&user.CreatedAt,
 		&user.UpdateAt,
+		&user.VerifiedAt,
+		&user.TOTPEnabled,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, dbError(ctx, err)
 	}
 
 	return &user, nil
 }
 
-func (m *PostgresDBRepo) GetUserById(id int) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUserById(ctx context.Context, id int) (*models.User, error) {
+	ctx, cancel := m.withTimeout(ctx, "GetUserById")
 	defer cancel()
 
 	query := `
 		select 
-			id, email, first_name, last_name, password, created_at, updated_at
+			id, email, first_name, last_name, password, created_at, updated_at,
+			verified_at,
+			exists(select 1 from user_totp t where t.user_id = users.id and t.enabled_at is not null)
 		from
 		    users
 		where id=$1
 	`
 	var user models.User
-	row := m.Db.QueryRowContext(ctx, query, id)
+	row := m.executor().QueryRowContext(ctx, query, id)
 	err := row.Scan(
 		&user.ID,
 		&user.Email,
@@ -237,24 +436,318 @@ func (m *PostgresDBRepo) GetUserById(id int) (*models.User, error) {
 		&user.Password,
 		&user.CreatedAt,
 		&user.UpdateAt,
+		&user.VerifiedAt,
+		&user.TOTPEnabled,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, dbError(ctx, err)
 	}
 
 	return &user, nil
 }
 
-func (m *PostgresDBRepo) AllGenres() ([]*models.Genre, error) {
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	ctx, cancel := m.withTimeout(ctx, "InsertUser")
+	defer cancel()
+
+	stmt := `insert into users (first_name, last_name, email, password, created_at, updated_at,
+				verified_at)
+				values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	var newId int
+	err := m.executor().QueryRowContext(ctx, stmt,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.Password,
+		user.CreatedAt,
+		user.UpdateAt,
+		user.VerifiedAt,
+	).Scan(&newId)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrEmailTaken
+	}
+	if err != nil {
+		return 0, dbError(ctx, err)
+	}
+	return newId, nil
+}
+
+func (m *PostgresDBRepo) UpdateUser(ctx context.Context, user models.User) error {
+	ctx, cancel := m.withTimeout(ctx, "UpdateUser")
+	defer cancel()
+
+	stmt := `update users set first_name = $1, last_name = $2, email = $3, updated_at = $4,
+				verified_at = $5
+				where id = $6`
+	result, err := m.executor().ExecContext(ctx, stmt,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.UpdateAt,
+		user.VerifiedAt,
+		user.ID,
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrEmailTaken
+	}
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if affected == 0 {
+		return repository.ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdateUserPassword sets a new password hash, keeping the old one in the
+// user's password history.
+func (m *PostgresDBRepo) UpdateUserPassword(ctx context.Context, id int, hash string) error {
+	ctx, cancel := m.withTimeout(ctx, "UpdateUserPassword")
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		now := time.Now()
+		stmt := `insert into password_history (user_id, password, created_at)
+				select id, password, $1 from users where id = $2 and password is not null`
+		_, err := tx.executor().ExecContext(ctx, stmt, now, id)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+
+		stmt = `update users set password = $1, updated_at = $2 where id = $3`
+		result, err := tx.executor().ExecContext(ctx, stmt, hash, now, id)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		if affected == 0 {
+			return repository.ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+// RehashUserPassword replaces a hash of the same password made with an
+// older algorithm or parameters. Nothing changes if the password was changed
+// since oldHash was read.
+func (m *PostgresDBRepo) RehashUserPassword(ctx context.Context, id int, oldHash, newHash string) error {
+	ctx, cancel := m.withTimeout(ctx, "RehashUserPassword")
+	defer cancel()
+
+	stmt := `update users set password = $1 where id = $2 and password = $3`
+	_, err := m.executor().ExecContext(ctx, stmt, newHash, id, oldHash)
+	return dbError(ctx, err)
+}
+
+// PasswordHistory returns the hashes of the user's current and previous
+// passwords, newest first, at most limit of them.
+func (m *PostgresDBRepo) PasswordHistory(ctx context.Context, id int, limit int) ([]string, error) {
+	ctx, cancel := m.withTimeout(ctx, "PasswordHistory")
+	defer cancel()
+
+	query := `
+		select password from (
+			select password, coalesce(updated_at, created_at) as changed_at from users where id = $1 and password is not null
+			union all
+			select password, created_at as changed_at from password_history where user_id = $1
+		) h
+		order by changed_at desc
+		limit $2
+	`
+	return m.names(ctx, query, id, limit)
+}
+
+// MarkUserVerified records that the user confirmed email. It fails when the
+// account's email has changed since the verification link was sent.
+func (m *PostgresDBRepo) MarkUserVerified(ctx context.Context, id int, email string) error {
+	ctx, cancel := m.withTimeout(ctx, "MarkUserVerified")
+	defer cancel()
+
+	stmt := `update users set verified_at = coalesce(verified_at, $1)
+				where id = $2 and lower(email) = lower($3)`
+	result, err := m.executor().ExecContext(ctx, stmt, time.Now(), id, email)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if affected == 0 {
+		return repository.ErrInvalidVerification
+	}
+	return nil
+}
+
+// TouchVerificationSent records that a verification email is being sent,
+// unless one already went out within interval, in which case it reports false.
+func (m *PostgresDBRepo) TouchVerificationSent(ctx context.Context, id int, interval time.Duration) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx, "TouchVerificationSent")
+	defer cancel()
+
+	now := time.Now()
+	stmt := `update users set verification_sent_at = $1
+				where id = $2 and (verification_sent_at is null or verification_sent_at <= $3)`
+	result, err := m.executor().ExecContext(ctx, stmt, now, id, now.Add(-interval))
+	if err != nil {
+		return false, dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, err)
+	}
+	return affected > 0, nil
+}
+
+func (m *PostgresDBRepo) AllRoles(ctx context.Context) ([]*models.Role, error) {
+	ctx, cancel := m.withTimeout(ctx, "AllRoles")
+	defer cancel()
+
+	query := `
+		select
+			r.id, r.name, coalesce(string_agg(p.name, ',' order by p.name), ''),
+			r.created_at, r.updated_at
+		from
+			roles r
+			left join role_permissions rp on (rp.role_id = r.id)
+			left join permissions p on (p.id = rp.permission_id)
+		group by r.id
+		order by r.id
+	`
+	rows, err := m.executor().QueryContext(ctx, query)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	defer rows.Close()
+	var roles []*models.Role
+	for rows.Next() {
+		var role models.Role
+		var permissions string
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&permissions,
+			&role.CreatedAt,
+			&role.UpdatedAt,
+		)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+		role.Permissions = splitNames(permissions)
+		roles = append(roles, &role)
+	}
+	return roles, nil
+}
+
+func (m *PostgresDBRepo) UserRoles(ctx context.Context, userId int) ([]string, error) {
+	ctx, cancel := m.withTimeout(ctx, "UserRoles")
+	defer cancel()
+
+	query := `
+		select r.name from user_roles ur
+		join roles r on (r.id = ur.role_id)
+		where ur.user_id = $1
+		order by r.name
+	`
+	return m.names(ctx, query, userId)
+}
+
+func (m *PostgresDBRepo) UserPermissions(ctx context.Context, userId int) ([]string, error) {
+	ctx, cancel := m.withTimeout(ctx, "UserPermissions")
+	defer cancel()
+
+	query := `
+		select distinct p.name from user_roles ur
+		join role_permissions rp on (rp.role_id = ur.role_id)
+		join permissions p on (p.id = rp.permission_id)
+		where ur.user_id = $1
+		order by p.name
+	`
+	return m.names(ctx, query, userId)
+}
+
+// SetUserRoles replaces the roles of a user with the named roles.
+func (m *PostgresDBRepo) SetUserRoles(ctx context.Context, userId int, roles []string) error {
+	ctx, cancel := m.withTimeout(ctx, "SetUserRoles")
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		var exists bool
+		err := tx.executor().QueryRowContext(ctx, `select exists(select 1 from users where id = $1)`, userId).Scan(&exists)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		if !exists {
+			return repository.ErrUserNotFound
+		}
+
+		_, err = tx.executor().ExecContext(ctx, `delete from user_roles where user_id = $1`, userId)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		if len(roles) == 0 {
+			return nil
+		}
+		stmt := `insert into user_roles (user_id, role_id)
+				select $1, id from roles where name = any($2::text[])`
+		result, err := tx.executor().ExecContext(ctx, stmt, userId, roles)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		if int(affected) != len(roles) {
+			return repository.ErrUnknownRole
+		}
+		return nil
+	})
+}
+
+// names runs a query returning a single text column and collects the values.
+func (m *PostgresDBRepo) names(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := m.executor().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func splitNames(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+	return strings.Split(list, ",")
+}
+
+func (m *PostgresDBRepo) AllGenres(ctx context.Context) ([]*models.Genre, error) {
 	var genres []*models.Genre
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := m.withTimeout(ctx, "AllGenres")
 	defer cancel()
 
 	query := `select id, genre, created_at, updated_at from genres order by genre`
-	rows, err := m.Db.QueryContext(ctx, query)
+	rows, err := m.executor().QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, dbError(ctx, err)
 	}
 	defer rows.Close()
 	for rows.Next() {
@@ -266,23 +759,50 @@ func (m *PostgresDBRepo) AllGenres() ([]*models.Genre, error) {
 			&g.UpdatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, dbError(ctx, err)
 		}
 		genres = append(genres, &g)
 	}
 	return genres, nil
 }
 
-func (m *PostgresDBRepo) InsertMovie(movie models.Movie) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// MissingGenreIds returns the ids in ids that have no row in genres.
+func (m *PostgresDBRepo) MissingGenreIds(ctx context.Context, ids []int) ([]int, error) {
+	ctx, cancel := m.withTimeout(ctx, "MissingGenreIds")
+	defer cancel()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	query := `select x from unnest($1::int[]) x where not exists (select 1 from genres g where g.id = x)`
+	rows, err := m.executor().QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	defer rows.Close()
+	var missing []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+		missing = append(missing, id)
+	}
+	return missing, nil
+}
+
+func (m *PostgresDBRepo) InsertMovie(ctx context.Context, movie models.Movie) (int, error) {
+	ctx, cancel := m.withTimeout(ctx, "InsertMovie")
 	defer cancel()
 
 	stmt := `insert into movies (title, description, release_date, runtime,
-				mpaa_rating, created_at, updated_at, image) values ($1, $2, 
-				$3, $4, $5, $6, $7, $8) returning id`
+				mpaa_rating, created_at, updated_at, image, created_by, updated_by)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, nullif($9, 0), nullif($10, 0))
+				returning id`
 	var newId int
 
-	err := m.Db.QueryRowContext(ctx, stmt,
+	err := m.executor().QueryRowContext(ctx, stmt,
 		movie.Title,
 		movie.Description,
 		movie.ReleaseDate,
@@ -291,29 +811,968 @@ func (m *PostgresDBRepo) InsertMovie(movie models.Movie) (int, error) {
 		movie.CreatedAt,
 		movie.UpdatedAt,
 		movie.Image,
+		movie.CreatedBy,
+		movie.UpdatedBy,
 	).Scan(&newId)
 
 	if err != nil {
-		return 0, err
+		return 0, dbError(ctx, err)
 	}
 	return newId, nil
 }
 
-func (m *PostgresDBRepo) UpdateMovieGenres(movieId int, genreIds []int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) UpdateMovieGenres(ctx context.Context, movieId int, genreIds []int) error {
+	ctx, cancel := m.withTimeout(ctx, "UpdateMovieGenres")
 	defer cancel()
 
-	stmt := `delete from movies_genres where movie_id=$1`
-	_, err := m.Db.ExecContext(ctx, stmt, movieId)
-	if err != nil {
-		return err
-	}
-	for _, n := range genreIds {
-		stmt := `insert into movies_genres(movie_id, genre_id) values ($1, $2)`
-		_, err := m.Db.ExecContext(ctx, stmt, movieId, n)
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		stmt := `delete from movies_genres where movie_id=$1`
+		_, err := tx.executor().ExecContext(ctx, stmt, movieId)
 		if err != nil {
-			return err
+			return dbError(ctx, err)
+		}
+		if len(genreIds) == 0 {
+			return nil
 		}
+		stmt = `insert into movies_genres(movie_id, genre_id)
+				select distinct $1::int, unnest($2::int[])`
+		_, err = tx.executor().ExecContext(ctx, stmt, movieId, genreIds)
+		return dbError(ctx, err)
+	})
+}
+
+func (m *PostgresDBRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	ctx, cancel := m.withTimeout(ctx, "UpdateMovie")
+	defer cancel()
+
+	stmt := `update movies set title = $1, description = $2, release_date = $3,
+				runtime = $4, mpaa_rating = $5, updated_at = $6, image = $7,
+				updated_by = nullif($8, 0)
+				where id = $9`
+
+	result, err := m.executor().ExecContext(ctx, stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.RunTime,
+		movie.MPAARating,
+		movie.UpdatedAt,
+		movie.Image,
+		movie.UpdatedBy,
+		movie.ID,
+	)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if affected == 0 {
+		return repository.ErrMovieNotFound
 	}
 	return nil
 }
+
+func (m *PostgresDBRepo) DeleteMovie(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx, "DeleteMovie")
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		stmt := `delete from movies_genres where movie_id=$1`
+		_, err := tx.executor().ExecContext(ctx, stmt, id)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+
+		stmt = `delete from movies where id=$1`
+		result, err := tx.executor().ExecContext(ctx, stmt, id)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		if affected == 0 {
+			return repository.ErrMovieNotFound
+		}
+		return nil
+	})
+}
+
+func (m *PostgresDBRepo) SearchMovies(ctx context.Context, term string, limit int) ([]*models.MovieSearchResult, error) {
+	ctx, cancel := m.withTimeout(ctx, "SearchMovies")
+	defer cancel()
+
+	query := `
+		select
+			m.id, m.title, m.mpaa_rating, m.release_date, m.runtime,
+			m.description, coalesce(m.image, ''), m.created_at, m.updated_at,
+			ts_rank(m.search_vector, q) as rank,
+			ts_headline('english', coalesce(m.description, ''), q,
+				'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10')
+		from
+			movies m, websearch_to_tsquery('english', $1) q
+		where
+			m.search_vector @@ q
+		order by
+			rank desc, m.title
+		limit $2
+	`
+	rows, err := m.executor().QueryContext(ctx, query, term, limit)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	defer rows.Close()
+	var results []*models.MovieSearchResult
+	for rows.Next() {
+		var result models.MovieSearchResult
+		err := rows.Scan(
+			&result.ID,
+			&result.Title,
+			&result.MPAARating,
+			&result.ReleaseDate,
+			&result.RunTime,
+			&result.Description,
+			&result.Image,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.Rank,
+			&result.Snippet,
+		)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+		results = append(results, &result)
+	}
+	return results, nil
+}
+
+// genreNameTaken reports whether another genre already uses name, ignoring case.
+func (m *PostgresDBRepo) genreNameTaken(ctx context.Context, name string, excludeId int) (bool, error) {
+	var exists bool
+	query := `select exists(select 1 from genres where lower(genre) = lower($1) and id <> $2)`
+	err := m.executor().QueryRowContext(ctx, query, name, excludeId).Scan(&exists)
+	return exists, dbError(ctx, err)
+}
+
+func (m *PostgresDBRepo) InsertGenre(ctx context.Context, genre models.Genre) (int, error) {
+	ctx, cancel := m.withTimeout(ctx, "InsertGenre")
+	defer cancel()
+
+	taken, err := m.genreNameTaken(ctx, genre.Genre, 0)
+	if err != nil {
+		return 0, dbError(ctx, err)
+	}
+	if taken {
+		return 0, repository.ErrGenreExists
+	}
+
+	stmt := `insert into genres (genre, created_at, updated_at) values ($1, $2, $3) returning id`
+	var newId int
+	err = m.executor().QueryRowContext(ctx, stmt,
+		genre.Genre,
+		genre.CreatedAt,
+		genre.UpdatedAt,
+	).Scan(&newId)
+	if err != nil {
+		return 0, dbError(ctx, err)
+	}
+	return newId, nil
+}
+
+func (m *PostgresDBRepo) UpdateGenre(ctx context.Context, genre models.Genre) error {
+	ctx, cancel := m.withTimeout(ctx, "UpdateGenre")
+	defer cancel()
+
+	taken, err := m.genreNameTaken(ctx, genre.Genre, genre.ID)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if taken {
+		return repository.ErrGenreExists
+	}
+
+	stmt := `update genres set genre = $1, updated_at = $2 where id = $3`
+	result, err := m.executor().ExecContext(ctx, stmt, genre.Genre, genre.UpdatedAt, genre.ID)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if affected == 0 {
+		return repository.ErrGenreNotFound
+	}
+	return nil
+}
+
+// DeleteGenre removes a genre. A genre still linked to movies is only deleted
+// when reassignTo names another genre, which then takes over those links.
+func (m *PostgresDBRepo) DeleteGenre(ctx context.Context, id int, reassignTo int) error {
+	ctx, cancel := m.withTimeout(ctx, "DeleteGenre")
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		var exists bool
+		err := tx.executor().QueryRowContext(ctx, `select exists(select 1 from genres where id = $1 for update)`, id).Scan(&exists)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		if !exists {
+			return repository.ErrGenreNotFound
+		}
+
+		var linked int
+		err = tx.executor().QueryRowContext(ctx, `select count(*) from movies_genres where genre_id = $1`, id).Scan(&linked)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+
+		if linked > 0 {
+			if reassignTo == 0 {
+				return repository.ErrGenreInUse
+			}
+			if reassignTo == id {
+				return repository.ErrInvalidReassignment
+			}
+			err = tx.executor().QueryRowContext(ctx, `select exists(select 1 from genres where id = $1)`, reassignTo).Scan(&exists)
+			if err != nil {
+				return dbError(ctx, err)
+			}
+			if !exists {
+				return repository.ErrInvalidReassignment
+			}
+
+			stmt := `insert into movies_genres (movie_id, genre_id)
+					select mg.movie_id, $2 from movies_genres mg
+					where mg.genre_id = $1
+					and not exists (select 1 from movies_genres x where x.movie_id = mg.movie_id and x.genre_id = $2)`
+			_, err = tx.executor().ExecContext(ctx, stmt, id, reassignTo)
+			if err != nil {
+				return dbError(ctx, err)
+			}
+			_, err = tx.executor().ExecContext(ctx, `delete from movies_genres where genre_id = $1`, id)
+			if err != nil {
+				return dbError(ctx, err)
+			}
+		}
+
+		_, err = tx.executor().ExecContext(ctx, `delete from genres where id = $1`, id)
+		return dbError(ctx, err)
+	})
+}
+
+func (m *PostgresDBRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	ctx, cancel := m.withTimeout(ctx, "InsertRefreshToken")
+	defer cancel()
+
+	stmt := `insert into refresh_tokens (id, user_id, family_id, expires_at, created_at)
+				values ($1, $2, $3, $4, $5)`
+	_, err := m.executor().ExecContext(ctx, stmt,
+		token.ID,
+		token.UserID,
+		token.FamilyID,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	return dbError(ctx, err)
+}
+
+func (m *PostgresDBRepo) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	ctx, cancel := m.withTimeout(ctx, "GetRefreshToken")
+	defer cancel()
+
+	query := `
+		select
+			id, user_id, family_id, expires_at, used_at,
+			coalesce(replaced_by, ''), revoked_at, created_at
+		from
+			refresh_tokens
+		where id = $1
+	`
+	var token models.RefreshToken
+	err := m.executor().QueryRowContext(ctx, query, id).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.ReplacedBy,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken marks usedId as spent and stores its replacement. When
+// usedId has already been spent or revoked, the token is being replayed: the
+// whole family is revoked and ErrRefreshTokenReused is returned.
+func (m *PostgresDBRepo) RotateRefreshToken(ctx context.Context, usedId string, next models.RefreshToken) error {
+	ctx, cancel := m.withTimeout(ctx, "RotateRefreshToken")
+	defer cancel()
+
+	err := m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		stmt := `update refresh_tokens set used_at = $1, replaced_by = $2
+				where id = $3 and used_at is null and revoked_at is null`
+		result, err := tx.executor().ExecContext(ctx, stmt, next.CreatedAt, next.ID, usedId)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		if affected == 0 {
+			return repository.ErrRefreshTokenReused
+		}
+		return tx.InsertRefreshToken(ctx, next)
+	})
+	if errors.Is(err, repository.ErrRefreshTokenReused) {
+		revokeErr := m.RevokeTokenFamily(ctx, next.FamilyID)
+		if revokeErr != nil {
+			return revokeErr
+		}
+	}
+	return err
+}
+
+func (m *PostgresDBRepo) RevokeTokenFamily(ctx context.Context, familyId string) error {
+	ctx, cancel := m.withTimeout(ctx, "RevokeTokenFamily")
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`
+	_, err := m.executor().ExecContext(ctx, stmt, time.Now(), familyId)
+	return dbError(ctx, err)
+}
+
+func (m *PostgresDBRepo) RevokeUserTokens(ctx context.Context, userId int) error {
+	ctx, cancel := m.withTimeout(ctx, "RevokeUserTokens")
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+	_, err := m.executor().ExecContext(ctx, stmt, time.Now(), userId)
+	return dbError(ctx, err)
+}
+
+func (m *PostgresDBRepo) InsertImpersonation(ctx context.Context, impersonation models.Impersonation) error {
+	ctx, cancel := m.withTimeout(ctx, "InsertImpersonation")
+	defer cancel()
+
+	//the emails are copied so the record still names both users after either
+	//account is deleted
+	stmt := `insert into impersonations (actor_id, actor_email, subject_id, subject_email, token_id, reason,
+				ip, user_agent, expires_at, created_at)
+				values ($1, (select email from users where id = $1), $2, (select email from users where id = $2),
+				$3, $4, $5, $6, $7, $8)`
+	_, err := m.executor().ExecContext(ctx, stmt,
+		impersonation.ActorID,
+		impersonation.SubjectID,
+		impersonation.TokenID,
+		impersonation.Reason,
+		impersonation.IP,
+		impersonation.UserAgent,
+		impersonation.ExpiresAt,
+		impersonation.CreatedAt,
+	)
+	return dbError(ctx, err)
+}
+
+// UserImpersonations returns who impersonated a user, most recent first.
+func (m *PostgresDBRepo) UserImpersonations(ctx context.Context, subjectId int) ([]*models.Impersonation, error) {
+	ctx, cancel := m.withTimeout(ctx, "UserImpersonations")
+	defer cancel()
+
+	query := `
+		select
+			id, actor_id, actor_email, subject_id, subject_email, token_id, reason, ip, user_agent,
+			expires_at, created_at
+		from
+			impersonations
+		where subject_id = $1
+		order by created_at desc
+	`
+	rows, err := m.executor().QueryContext(ctx, query, subjectId)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	defer rows.Close()
+	var impersonations []*models.Impersonation
+	for rows.Next() {
+		var i models.Impersonation
+		err := rows.Scan(
+			&i.ID,
+			&i.ActorID,
+			&i.ActorEmail,
+			&i.SubjectID,
+			&i.SubjectEmail,
+			&i.TokenID,
+			&i.Reason,
+			&i.IP,
+			&i.UserAgent,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+		)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+		impersonations = append(impersonations, &i)
+	}
+	return impersonations, nil
+}
+
+// RevokeAccessToken puts an access token on the denylist until it expires.
+// Entries whose tokens have expired anyway are cleared out at the same time.
+func (m *PostgresDBRepo) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := m.withTimeout(ctx, "RevokeAccessToken")
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		now := time.Now()
+		_, err := tx.executor().ExecContext(ctx, `delete from revoked_access_tokens where expires_at < $1`, now)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		stmt := `insert into revoked_access_tokens (jti, expires_at, revoked_at) values ($1, $2, $3)
+					on conflict (jti) do nothing`
+		_, err = tx.executor().ExecContext(ctx, stmt, jti, expiresAt, now)
+		return dbError(ctx, err)
+	})
+}
+
+// IsAccessTokenRevoked reports whether the access token jti was revoked, or
+// the session it was issued for has ended. Every way of ending a session
+// revokes the unrevoked tokens of its refresh token family, so one revoked
+// token in the family means the session is over.
+func (m *PostgresDBRepo) IsAccessTokenRevoked(ctx context.Context, jti, sessionId string) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx, "IsAccessTokenRevoked")
+	defer cancel()
+
+	var revoked bool
+	query := `select exists(select 1 from revoked_access_tokens where jti = $1)
+				or exists(select 1 from refresh_tokens where family_id = $2 and revoked_at is not null)`
+	err := m.executor().QueryRowContext(ctx, query, jti, sessionId).Scan(&revoked)
+	if err != nil {
+		return false, dbError(ctx, err)
+	}
+	return revoked, nil
+}
+
+func (m *PostgresDBRepo) InsertSession(ctx context.Context, session models.Session) error {
+	ctx, cancel := m.withTimeout(ctx, "InsertSession")
+	defer cancel()
+
+	stmt := `insert into sessions (id, user_id, user_agent, ip, created_at, last_used_at)
+				values ($1, $2, $3, $4, $5, $6)`
+	_, err := m.executor().ExecContext(ctx, stmt,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		session.CreatedAt,
+		session.LastUsedAt,
+	)
+	return dbError(ctx, err)
+}
+
+// TouchSession records that a session's refresh token was used, and from
+// where.
+func (m *PostgresDBRepo) TouchSession(ctx context.Context, id, userAgent, ip string) error {
+	ctx, cancel := m.withTimeout(ctx, "TouchSession")
+	defer cancel()
+
+	stmt := `update sessions set last_used_at = $1, user_agent = $2, ip = $3 where id = $4`
+	_, err := m.executor().ExecContext(ctx, stmt, time.Now(), userAgent, ip, id)
+	return dbError(ctx, err)
+}
+
+// UserSessions returns the user's active sessions, most recently used first.
+func (m *PostgresDBRepo) UserSessions(ctx context.Context, userId int) ([]*models.Session, error) {
+	ctx, cancel := m.withTimeout(ctx, "UserSessions")
+	defer cancel()
+
+	query := `
+		select
+			s.id, s.user_id, s.user_agent, s.ip, s.created_at, s.last_used_at
+		from
+			sessions s
+		where
+			s.user_id = $1
+			and exists (select 1 from refresh_tokens t
+				where t.family_id = s.id and t.used_at is null and t.revoked_at is null and t.expires_at > $2)
+		order by s.last_used_at desc
+	`
+	rows, err := m.executor().QueryContext(ctx, query, userId, time.Now())
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	defer rows.Close()
+	var sessions []*models.Session
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+		)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the user's sessions by revoking its refresh
+// tokens; IsAccessTokenRevoked then refuses the session's access tokens too.
+func (m *PostgresDBRepo) RevokeSession(ctx context.Context, userId int, id string) error {
+	ctx, cancel := m.withTimeout(ctx, "RevokeSession")
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1
+				where user_id = $2 and family_id = $3 and revoked_at is null`
+	result, err := m.executor().ExecContext(ctx, stmt, time.Now(), userId, id)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if affected == 0 {
+		return repository.ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions ends every session of the user except keepId.
+func (m *PostgresDBRepo) RevokeOtherSessions(ctx context.Context, userId int, keepId string) error {
+	ctx, cancel := m.withTimeout(ctx, "RevokeOtherSessions")
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1
+				where user_id = $2 and family_id <> $3 and revoked_at is null`
+	_, err := m.executor().ExecContext(ctx, stmt, time.Now(), userId, keepId)
+	return dbError(ctx, err)
+}
+
+func (m *PostgresDBRepo) InsertPasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	ctx, cancel := m.withTimeout(ctx, "InsertPasswordReset")
+	defer cancel()
+
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at)
+				values ($1, $2, $3, $4)`
+	_, err := m.executor().ExecContext(ctx, stmt,
+		reset.UserID,
+		reset.TokenHash,
+		reset.ExpiresAt,
+		reset.CreatedAt,
+	)
+	return dbError(ctx, err)
+}
+
+// TouchPasswordResetSent records that a password reset email is being sent,
+// unless one already went out within interval, in which case it reports false.
+func (m *PostgresDBRepo) TouchPasswordResetSent(ctx context.Context, id int, interval time.Duration) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx, "TouchPasswordResetSent")
+	defer cancel()
+
+	now := time.Now()
+	stmt := `update users set password_reset_sent_at = $1
+				where id = $2 and (password_reset_sent_at is null or password_reset_sent_at <= $3)`
+	result, err := m.executor().ExecContext(ctx, stmt, now, id, now.Add(-interval))
+	if err != nil {
+		return false, dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, err)
+	}
+	return affected > 0, nil
+}
+
+// ConsumePasswordReset marks the unexpired, unused reset with tokenHash as
+// used, along with any other outstanding resets of the same user, and
+// returns the user's id.
+func (m *PostgresDBRepo) ConsumePasswordReset(ctx context.Context, tokenHash string) (int, error) {
+	ctx, cancel := m.withTimeout(ctx, "ConsumePasswordReset")
+	defer cancel()
+
+	var userId int
+	err := m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		now := time.Now()
+		stmt := `update password_resets set used_at = $1
+				where token_hash = $2 and used_at is null and expires_at > $1
+				returning user_id`
+		err := tx.executor().QueryRowContext(ctx, stmt, now, tokenHash).Scan(&userId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return repository.ErrInvalidResetToken
+		}
+		if err != nil {
+			return dbError(ctx, err)
+		}
+
+		stmt = `update password_resets set used_at = $1 where user_id = $2 and used_at is null`
+		_, err = tx.executor().ExecContext(ctx, stmt, now, userId)
+		return dbError(ctx, err)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
+func (m *PostgresDBRepo) GetTOTP(ctx context.Context, userId int) (*models.TOTP, error) {
+	ctx, cancel := m.withTimeout(ctx, "GetTOTP")
+	defer cancel()
+
+	query := `
+		select user_id, secret, enabled_at, coalesce(last_step, 0), created_at
+		from user_totp
+		where user_id = $1
+	`
+	var t models.TOTP
+	err := m.executor().QueryRowContext(ctx, query, userId).Scan(
+		&t.UserID,
+		&t.Secret,
+		&t.EnabledAt,
+		&t.LastStep,
+		&t.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repository.ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	return &t, nil
+}
+
+// SetTOTPSecret starts a new, not yet enabled, enrollment for the user.
+func (m *PostgresDBRepo) SetTOTPSecret(ctx context.Context, userId int, secret string) error {
+	ctx, cancel := m.withTimeout(ctx, "SetTOTPSecret")
+	defer cancel()
+
+	stmt := `insert into user_totp (user_id, secret, created_at) values ($1, $2, $3)
+				on conflict (user_id) do update
+				set secret = excluded.secret, enabled_at = null, last_step = null, created_at = excluded.created_at`
+	_, err := m.executor().ExecContext(ctx, stmt, userId, secret, time.Now())
+	return dbError(ctx, err)
+}
+
+// EnableTOTP turns on a confirmed enrollment and replaces the user's
+// recovery codes.
+func (m *PostgresDBRepo) EnableTOTP(ctx context.Context, userId int, step int64, recoveryCodeHashes []string) error {
+	ctx, cancel := m.withTimeout(ctx, "EnableTOTP")
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		now := time.Now()
+		stmt := `update user_totp set enabled_at = $1, last_step = $2 where user_id = $3`
+		result, err := tx.executor().ExecContext(ctx, stmt, now, step, userId)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		if affected == 0 {
+			return repository.ErrTOTPNotEnrolled
+		}
+
+		_, err = tx.executor().ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, userId)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		stmt = `insert into user_recovery_codes (user_id, code_hash, created_at)
+				select $1, unnest($2::text[]), $3`
+		_, err = tx.executor().ExecContext(ctx, stmt, userId, recoveryCodeHashes, now)
+		return dbError(ctx, err)
+	})
+}
+
+func (m *PostgresDBRepo) DisableTOTP(ctx context.Context, userId int) error {
+	ctx, cancel := m.withTimeout(ctx, "DisableTOTP")
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		_, err := tx.executor().ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, userId)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		_, err = tx.executor().ExecContext(ctx, `delete from user_totp where user_id = $1`, userId)
+		return dbError(ctx, err)
+	})
+}
+
+// UseTOTPStep records step as the last one a code was accepted for. It
+// reports false when that step, or a later one, was already used, so the
+// same code cannot be replayed.
+func (m *PostgresDBRepo) UseTOTPStep(ctx context.Context, userId int, step int64) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx, "UseTOTPStep")
+	defer cancel()
+
+	stmt := `update user_totp set last_step = $1
+				where user_id = $2 and enabled_at is not null and (last_step is null or last_step < $1)`
+	result, err := m.executor().ExecContext(ctx, stmt, step, userId)
+	if err != nil {
+		return false, dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, err)
+	}
+	return affected > 0, nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes, reporting false
+// when no unused code has that hash.
+func (m *PostgresDBRepo) UseRecoveryCode(ctx context.Context, userId int, codeHash string) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx, "UseRecoveryCode")
+	defer cancel()
+
+	stmt := `update user_recovery_codes set used_at = $1
+				where user_id = $2 and code_hash = $3 and used_at is null`
+	result, err := m.executor().ExecContext(ctx, stmt, time.Now(), userId, codeHash)
+	if err != nil {
+		return false, dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError(ctx, err)
+	}
+	return affected > 0, nil
+}
+
+func (m *PostgresDBRepo) LoginAttempts(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	ctx, cancel := m.withTimeout(ctx, "LoginAttempts")
+	defer cancel()
+
+	query := `select key, failures, last_failure_at from login_attempts where key = $1`
+	a := models.LoginAttempt{Key: key}
+	err := m.executor().QueryRowContext(ctx, query, key).Scan(
+		&a.Key,
+		&a.Failures,
+		&a.LastFailureAt,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, dbError(ctx, err)
+	}
+	return &a, nil
+}
+
+// RecordLoginFailure counts a failed sign-in for key in a single statement,
+// so concurrent failures from several instances are all counted.
+func (m *PostgresDBRepo) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*models.LoginAttempt, error) {
+	ctx, cancel := m.withTimeout(ctx, "RecordLoginFailure")
+	defer cancel()
+
+	now := time.Now()
+	stmt := `insert into login_attempts (key, failures, last_failure_at) values ($1, 1, $2)
+				on conflict (key) do update
+				set failures = case when login_attempts.last_failure_at < $3 then 1 else login_attempts.failures + 1 end,
+					last_failure_at = excluded.last_failure_at
+				returning key, failures, last_failure_at`
+	var a models.LoginAttempt
+	err := m.executor().QueryRowContext(ctx, stmt, key, now, now.Add(-window)).Scan(
+		&a.Key,
+		&a.Failures,
+		&a.LastFailureAt,
+	)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	return &a, nil
+}
+
+func (m *PostgresDBRepo) ResetLoginAttempts(ctx context.Context, key string) error {
+	ctx, cancel := m.withTimeout(ctx, "ResetLoginAttempts")
+	defer cancel()
+
+	_, err := m.executor().ExecContext(ctx, `delete from login_attempts where key = $1`, key)
+	return dbError(ctx, err)
+}
+
+const apiKeyColumns = `
+			k.id, k.user_id, k.name, k.prefix, k.key_hash,
+			coalesce((select string_agg(p.name, ',' order by p.name)
+				from api_key_permissions kp join permissions p on (p.id = kp.permission_id)
+				where kp.api_key_id = k.id), ''),
+			k.expires_at, k.last_used_at, k.revoked_at, k.created_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = splitNames(scopes)
+	return &key, nil
+}
+
+func (m *PostgresDBRepo) AllAPIKeys(ctx context.Context) ([]*models.APIKey, error) {
+	ctx, cancel := m.withTimeout(ctx, "AllAPIKeys")
+	defer cancel()
+
+	query := `select` + apiKeyColumns + ` from api_keys k order by k.id`
+	rows, err := m.executor().QueryContext(ctx, query)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	defer rows.Close()
+	var keys []*models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByHash finds a key by the hash of its value. Revoked and expired
+// keys are returned too; callers check Active.
+func (m *PostgresDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	ctx, cancel := m.withTimeout(ctx, "GetAPIKeyByHash")
+	defer cancel()
+
+	query := `select` + apiKeyColumns + ` from api_keys k where k.key_hash = $1`
+	key, err := scanAPIKey(m.executor().QueryRowContext(ctx, query, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repository.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	return key, nil
+}
+
+func (m *PostgresDBRepo) InsertAPIKey(ctx context.Context, key *models.APIKey) (int, error) {
+	ctx, cancel := m.withTimeout(ctx, "InsertAPIKey")
+	defer cancel()
+
+	var newID int
+	err := m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		stmt := `insert into api_keys (user_id, name, prefix, key_hash, expires_at, created_at)
+				values ($1, $2, $3, $4, $5, $6) returning id`
+		err := tx.executor().QueryRowContext(ctx, stmt,
+			key.UserID,
+			key.Name,
+			key.Prefix,
+			key.KeyHash,
+			key.ExpiresAt,
+			time.Now(),
+		).Scan(&newID)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+
+		stmt = `insert into api_key_permissions (api_key_id, permission_id)
+				select $1, id from permissions where name = any($2::text[])`
+		result, err := tx.executor().ExecContext(ctx, stmt, newID, key.Scopes)
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return dbError(ctx, err)
+		}
+		if int(affected) != len(key.Scopes) {
+			return repository.ErrUnknownPermission
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// TouchAPIKey records that a key was used. It writes at most once a minute
+// per key so busy clients do not turn every request into an update.
+func (m *PostgresDBRepo) TouchAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx, "TouchAPIKey")
+	defer cancel()
+
+	now := time.Now()
+	stmt := `update api_keys set last_used_at = $1
+				where id = $2 and (last_used_at is null or last_used_at < $3)`
+	_, err := m.executor().ExecContext(ctx, stmt, now, id, now.Add(-time.Minute))
+	return dbError(ctx, err)
+}
+
+func (m *PostgresDBRepo) RevokeAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx, "RevokeAPIKey")
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = coalesce(revoked_at, $1) where id = $2`
+	result, err := m.executor().ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if affected == 0 {
+		return repository.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// GetUserByIdentity returns the user linked to an external identity and
+// records the login on the link.
+func (m *PostgresDBRepo) GetUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	ctx, cancel := m.withTimeout(ctx, "GetUserByIdentity")
+	defer cancel()
+
+	stmt := `update user_identities set last_login_at = $1
+				where provider = $2 and subject = $3 returning user_id`
+	var userId int
+	err := m.executor().QueryRowContext(ctx, stmt, time.Now(), provider, subject).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repository.ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	return m.GetUserById(ctx, userId)
+}
+
+func (m *PostgresDBRepo) LinkIdentity(ctx context.Context, identity models.Identity) error {
+	ctx, cancel := m.withTimeout(ctx, "LinkIdentity")
+	defer cancel()
+
+	stmt := `insert into user_identities (provider, subject, user_id, email, created_at, last_login_at)
+				values ($1, $2, $3, $4, $5, $5)`
+	_, err := m.executor().ExecContext(ctx, stmt,
+		identity.Provider,
+		identity.Subject,
+		identity.UserID,
+		identity.Email,
+		time.Now(),
+	)
+	return dbError(ctx, err)
+}
@@ -0,0 +1,106 @@
+package dbrepo
+
+import (
+	"go-restapi/inernal/repository"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMovieFilters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query repository.MovieQuery
+		where string
+		args  []any
+	}{
+		{
+			name:  "no filters",
+			query: repository.MovieQuery{},
+			where: "",
+			args:  nil,
+		},
+		{
+			name:  "single filter",
+			query: repository.MovieQuery{MPAARating: "PG"},
+			where: "where mpaa_rating = $1",
+			args:  []any{"PG"},
+		},
+		{
+			name: "combined filters",
+			query: repository.MovieQuery{
+				GenreID:    3,
+				MPAARating: "R",
+				YearFrom:   1990,
+				YearTo:     1999,
+				RuntimeMin: 90,
+				RuntimeMax: 150,
+			},
+			where: "where id in (select movie_id from movies_genres where genre_id = $1)" +
+				" and mpaa_rating = $2" +
+				" and extract(year from release_date) >= $3" +
+				" and extract(year from release_date) <= $4" +
+				" and runtime >= $5" +
+				" and runtime <= $6",
+			args: []any{3, "R", 1990, 1999, 90, 150},
+		},
+		{
+			name:  "placeholders follow the filters that are set",
+			query: repository.MovieQuery{YearTo: 2000, RuntimeMax: 120},
+			where: "where extract(year from release_date) <= $1 and runtime <= $2",
+			args:  []any{2000, 120},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := movieFilters(tt.query)
+			if where != tt.where {
+				t.Errorf("where = %q, want %q", where, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestMovieListQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   repository.MovieQuery
+		orderBy string
+	}{
+		{"default sort", repository.MovieQuery{}, "title asc, id asc"},
+		{"whitelisted column", repository.MovieQuery{Sort: "release_date"}, "release_date asc, id asc"},
+		{"descending", repository.MovieQuery{Sort: "runtime", Desc: true}, "runtime desc, id desc"},
+		{"unknown column", repository.MovieQuery{Sort: "id; drop table movies"}, "title asc, id asc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := movieListQuery(tt.query, "", nil)
+			if !strings.Contains(strings.Join(strings.Fields(query), " "), "order by "+tt.orderBy+" limit") {
+				t.Errorf("query does not order by %q:\n%s", tt.orderBy, query)
+			}
+		})
+	}
+}
+
+func TestMovieListQueryPaging(t *testing.T) {
+	q := repository.MovieQuery{Page: 3, PageSize: 25, GenreID: 4, MPAARating: "PG-13"}
+	where, args := movieFilters(q)
+	query, args := movieListQuery(q, where, args)
+
+	query = strings.Join(strings.Fields(query), " ")
+	if !strings.Contains(query, "movies where id in") || !strings.Contains(query, "and mpaa_rating = $2 order by") {
+		t.Errorf("query does not include the filters:\n%s", query)
+	}
+	if !strings.HasSuffix(query, "limit $3 offset $4") {
+		t.Errorf("limit and offset placeholders do not follow the filters:\n%s", query)
+	}
+	want := []any{4, "PG-13", 25, 50}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
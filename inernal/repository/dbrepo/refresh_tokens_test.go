@@ -0,0 +1,121 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-restapi/inernal/models"
+	"go-restapi/inernal/repository"
+	"testing"
+	"time"
+)
+
+func refreshToken(userID int, family, id string) models.RefreshToken {
+	return models.RefreshToken{
+		ID:        id,
+		UserID:    userID,
+		FamilyID:  family,
+		ExpiresAt: time.Now().Add(time.Hour),
+		CreatedAt: time.Now(),
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	m := testRepo(t)
+	ctx := context.Background()
+	userID := testUser(t, m)
+	family := fmt.Sprintf("family-%d", time.Now().UnixNano())
+
+	err := m.InsertRefreshToken(ctx, refreshToken(userID, family, family+"-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.RotateRefreshToken(ctx, family+"-1", refreshToken(userID, family, family+"-2"))
+	if err != nil {
+		t.Fatalf("first rotation: %v", err)
+	}
+
+	used, err := m.GetRefreshToken(ctx, family+"-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used.UsedAt == nil || used.ReplacedBy != family+"-2" {
+		t.Errorf("used token not marked spent: %+v", used)
+	}
+
+	//a spent token cannot be rotated again, and trying revokes the family
+	err = m.RotateRefreshToken(ctx, family+"-1", refreshToken(userID, family, family+"-3"))
+	if !errors.Is(err, repository.ErrRefreshTokenReused) {
+		t.Fatalf("reusing a spent token: err = %v, want ErrRefreshTokenReused", err)
+	}
+	_, err = m.GetRefreshToken(ctx, family+"-3")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("replacement for a reused token was stored: %v", err)
+	}
+	current, err := m.GetRefreshToken(ctx, family+"-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.RevokedAt == nil {
+		t.Error("family was not revoked after reuse")
+	}
+
+	//nor can a token from the revoked family
+	err = m.RotateRefreshToken(ctx, family+"-2", refreshToken(userID, family, family+"-4"))
+	if !errors.Is(err, repository.ErrRefreshTokenReused) {
+		t.Errorf("rotating a revoked token: err = %v, want ErrRefreshTokenReused", err)
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	m := testRepo(t)
+	ctx := context.Background()
+	userID := testUser(t, m)
+	prefix := fmt.Sprintf("user-%d", time.Now().UnixNano())
+
+	for _, family := range []string{prefix + "-a", prefix + "-b"} {
+		err := m.InsertRefreshToken(ctx, refreshToken(userID, family, family+"-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := m.RevokeUserTokens(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range []string{prefix + "-a", prefix + "-b"} {
+		token, err := m.GetRefreshToken(ctx, family+"-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.RevokedAt == nil {
+			t.Errorf("token of family %s was not revoked", family)
+		}
+	}
+}
+
+func TestRevokedSessionRevokesAccessTokens(t *testing.T) {
+	m := testRepo(t)
+	ctx := context.Background()
+	userID := testUser(t, m)
+	family := fmt.Sprintf("session-%d", time.Now().UnixNano())
+
+	err := m.InsertRefreshToken(ctx, refreshToken(userID, family, family+"-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := m.IsAccessTokenRevoked(ctx, family+"-jti", family)
+	if err != nil || revoked {
+		t.Fatalf("live session: revoked = %v, %v", revoked, err)
+	}
+
+	err = m.RevokeSession(ctx, userID, family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err = m.IsAccessTokenRevoked(ctx, family+"-jti", family)
+	if err != nil || !revoked {
+		t.Errorf("revoked session: revoked = %v, %v", revoked, err)
+	}
+}
@@ -0,0 +1,68 @@
+package dbrepo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUseRecoveryCode(t *testing.T) {
+	m := testRepo(t)
+	ctx := context.Background()
+	userID := testUser(t, m)
+
+	err := m.SetTOTPSecret(ctx, userID, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.EnableTOTP(ctx, userID, 100, []string{"hash-a", "hash-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, false} {
+		ok, err := m.UseRecoveryCode(ctx, userID, "hash-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("use %d of the same code: ok = %v, want %v", i+1, ok, want)
+		}
+	}
+	ok, err := m.UseRecoveryCode(ctx, userID, "hash-b")
+	if err != nil || !ok {
+		t.Errorf("other code: ok = %v, err = %v", ok, err)
+	}
+	ok, err = m.UseRecoveryCode(ctx, userID, "unknown")
+	if err != nil || ok {
+		t.Errorf("unknown code: ok = %v, err = %v", ok, err)
+	}
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	m := testRepo(t)
+	ctx := context.Background()
+	userID := testUser(t, m)
+
+	err := m.SetTOTPSecret(ctx, userID, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.EnableTOTP(ctx, userID, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the step used to confirm enrollment and earlier ones are spent
+	for _, tt := range []struct {
+		step int64
+		want bool
+	}{{99, false}, {100, false}, {101, true}, {101, false}, {103, true}, {102, false}} {
+		ok, err := m.UseTOTPStep(ctx, userID, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.want {
+			t.Errorf("step %d: ok = %v, want %v", tt.step, ok, tt.want)
+		}
+	}
+}
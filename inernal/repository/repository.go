@@ -1,18 +1,117 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"go-restapi/inernal/apperror"
 	"go-restapi/inernal/models"
+	"time"
+)
+
+var (
+	ErrCanceled            = errors.New("request canceled")
+	ErrTimeout             = errors.New("database operation timed out")
+	ErrRefreshTokenReused  = apperror.Unauthorized("refresh token has already been used")
+	ErrSessionNotFound     = apperror.NotFound("session not found")
+	ErrInvalidResetToken   = apperror.Validation("password reset token is invalid or has expired")
+	ErrInvalidVerification = apperror.Validation("verification link is invalid or has expired")
+	ErrTOTPNotEnrolled     = apperror.NotFound("two-factor authentication is not set up")
+	ErrEmailTaken          = apperror.Conflict("an account with this email already exists")
+	ErrMovieNotFound       = apperror.NotFound("movie not found")
+	ErrIdentityNotFound    = apperror.NotFound("no account is linked to this identity")
+	ErrAPIKeyNotFound      = apperror.NotFound("api key not found")
+	ErrUnknownPermission   = apperror.Validation("one or more permissions do not exist")
+	ErrUnknownRole         = apperror.Validation("one or more roles do not exist")
+	ErrUserNotFound        = apperror.NotFound("user not found")
+	ErrGenreNotFound       = apperror.NotFound("genre not found")
+	ErrGenreExists         = apperror.Conflict("a genre with this name already exists")
+	ErrGenreInUse          = apperror.Conflict("genre is still linked to movies")
+	ErrInvalidReassignment = apperror.Validation("movies must be reassigned to a different, existing genre")
 )
 
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllMovies() ([]*models.Movie, error)
-	GetUserByEmail(email string) (*models.User, error)
-	GetUserById(id int) (*models.User, error)
-	OneMovie(id int) (*models.Movie, error)
-	OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error)
-	AllGenres() ([]*models.Genre, error)
-	InsertMovie(movie models.Movie) (int, error)
-	UpdateMovieGenres(movieId int, genreIds []int) error
+	WithTx(ctx context.Context, fn func(repo DatabaseRepo) error) error
+	AllMovies(ctx context.Context, query MovieQuery) ([]*models.Movie, int, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserById(ctx context.Context, id int) (*models.User, error)
+	InsertUser(ctx context.Context, user models.User) (int, error)
+	UpdateUser(ctx context.Context, user models.User) error
+	UpdateUserPassword(ctx context.Context, id int, hash string) error
+	RehashUserPassword(ctx context.Context, id int, oldHash, newHash string) error
+	PasswordHistory(ctx context.Context, id int, limit int) ([]string, error)
+	MarkUserVerified(ctx context.Context, id int, email string) error
+	TouchVerificationSent(ctx context.Context, id int, interval time.Duration) (bool, error)
+	AllRoles(ctx context.Context) ([]*models.Role, error)
+	UserRoles(ctx context.Context, userId int) ([]string, error)
+	UserPermissions(ctx context.Context, userId int) ([]string, error)
+	SetUserRoles(ctx context.Context, userId int, roles []string) error
+	OneMovie(ctx context.Context, id int) (*models.Movie, error)
+	OneMovieForEdit(ctx context.Context, id int) (*models.Movie, []*models.Genre, error)
+	AllGenres(ctx context.Context) ([]*models.Genre, error)
+	MissingGenreIds(ctx context.Context, ids []int) ([]int, error)
+	InsertMovie(ctx context.Context, movie models.Movie) (int, error)
+	UpdateMovieGenres(ctx context.Context, movieId int, genreIds []int) error
+	UpdateMovie(ctx context.Context, movie models.Movie) error
+	DeleteMovie(ctx context.Context, id int) error
+	SearchMovies(ctx context.Context, term string, limit int) ([]*models.MovieSearchResult, error)
+	InsertGenre(ctx context.Context, genre models.Genre) (int, error)
+	UpdateGenre(ctx context.Context, genre models.Genre) error
+	DeleteGenre(ctx context.Context, id int, reassignTo int) error
+	InsertRefreshToken(ctx context.Context, token models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, usedId string, next models.RefreshToken) error
+	RevokeTokenFamily(ctx context.Context, familyId string) error
+	RevokeUserTokens(ctx context.Context, userId int) error
+	InsertImpersonation(ctx context.Context, impersonation models.Impersonation) error
+	UserImpersonations(ctx context.Context, subjectId int) ([]*models.Impersonation, error)
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti, sessionId string) (bool, error)
+	InsertSession(ctx context.Context, session models.Session) error
+	TouchSession(ctx context.Context, id, userAgent, ip string) error
+	UserSessions(ctx context.Context, userId int) ([]*models.Session, error)
+	RevokeSession(ctx context.Context, userId int, id string) error
+	RevokeOtherSessions(ctx context.Context, userId int, keepId string) error
+	GetTOTP(ctx context.Context, userId int) (*models.TOTP, error)
+	SetTOTPSecret(ctx context.Context, userId int, secret string) error
+	EnableTOTP(ctx context.Context, userId int, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userId int) error
+	UseTOTPStep(ctx context.Context, userId int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userId int, codeHash string) (bool, error)
+	GetUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error)
+	LinkIdentity(ctx context.Context, identity models.Identity) error
+	AllAPIKeys(ctx context.Context) ([]*models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	InsertAPIKey(ctx context.Context, key *models.APIKey) (int, error)
+	TouchAPIKey(ctx context.Context, id int) error
+	RevokeAPIKey(ctx context.Context, id int) error
+	LoginAttempts(ctx context.Context, key string) (*models.LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*models.LoginAttempt, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+	InsertPasswordReset(ctx context.Context, reset models.PasswordReset) error
+	TouchPasswordResetSent(ctx context.Context, id int, interval time.Duration) (bool, error)
+	ConsumePasswordReset(ctx context.Context, tokenHash string) (int, error)
+}
+
+// MovieQuery carries paging, sorting and filtering options for AllMovies.
+// Zero values mean "no filter".
+type MovieQuery struct {
+	Page       int
+	PageSize   int
+	Sort       string
+	Desc       bool
+	GenreID    int
+	MPAARating string
+	YearFrom   int
+	YearTo     int
+	RuntimeMin int
+	RuntimeMax int
+}
+
+// MovieSortFields lists the columns movies may be sorted by.
+var MovieSortFields = []string{"title", "release_date", "runtime"}
+
+func (q MovieQuery) Offset() int {
+	return (q.Page - 1) * q.PageSize
 }
@@ -0,0 +1,103 @@
+package totp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890",
+// base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC lists 8 digit codes; with 6 digits a code is the last six of them.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		want := v.code[len(v.code)-Digits:]
+		got, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Code at %d = %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseAndPaddedSecrets(t *testing.T) {
+	want, _ := Code(rfcSecret, 1)
+	for _, secret := range []string{strings.ToLower(rfcSecret), rfcSecret + "===="} {
+		got, err := Code(secret, 1)
+		if err != nil || got != want {
+			t.Errorf("Code(%q) = %s, %v, want %s", secret, got, err, want)
+		}
+	}
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidateRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		at := time.Unix(v.unix, 0)
+		step, ok := Validate(rfcSecret, v.code[len(v.code)-Digits:], at)
+		if !ok || step != Step(at) {
+			t.Errorf("Validate at %d = %d, %v, want %d, true", v.unix, step, ok, Step(at))
+		}
+	}
+}
+
+func TestValidateSkewWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	for offset := int64(-3); offset <= 3; offset++ {
+		code, err := Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		inWindow := offset >= -Skew && offset <= Skew
+		if ok != inWindow {
+			t.Errorf("code %+d steps away: accepted = %v, want %v", offset, ok, inWindow)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code %+d steps away matched step %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := Code(rfcSecret, Step(now))
+	for _, c := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := Validate(rfcSecret, c, now); ok {
+			t.Errorf("Validate accepted %q", c)
+		}
+	}
+	if _, ok := Validate(rfcSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("Validate rejected a code typed with a space")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Movies", "ann@example.com", rfcSecret)
+	want := fmt.Sprintf("otpauth://totp/Movies:ann@example.com?algorithm=SHA1&digits=6&issuer=Movies&period=30&secret=%s", rfcSecret)
+	if uri != want {
+		t.Errorf("ProvisioningURI = %s, want %s", uri, want)
+	}
+}
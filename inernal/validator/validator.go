@@ -0,0 +1,67 @@
+package validator
+
+import (
+	"go-restapi/inernal/apperror"
+	"regexp"
+)
+
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// Validator collects every field error of a payload so they can be reported
+// together instead of one at a time.
+type Validator struct {
+	Errors []apperror.FieldError
+}
+
+func New() *Validator {
+	return &Validator{}
+}
+
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+func (v *Validator) AddError(field, message string) {
+	v.Errors = append(v.Errors, apperror.FieldError{Field: field, Message: message})
+}
+
+// Check adds an error for field when ok is false.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.AddError(field, message)
+	}
+}
+
+// Err returns a validation error carrying the collected field errors, or nil.
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+	err := apperror.Validation("request failed validation")
+	err.Fields = v.Errors
+	return err
+}
+
+func In(value string, list ...string) bool {
+	for _, item := range list {
+		if value == item {
+			return true
+		}
+	}
+	return false
+}
+
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
+func Unique[T comparable](values []T) bool {
+	seen := make(map[T]bool, len(values))
+	for _, value := range values {
+		if seen[value] {
+			return false
+		}
+		seen[value] = true
+	}
+	return true
+}